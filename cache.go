@@ -0,0 +1,96 @@
+/*
+ * Copyright (c) 2019 Zenichi Amano
+ *
+ * This file is part of http-ece, which is MIT licensed.
+ * See http://opensource.org/licenses/MIT
+ */
+
+package httpece
+
+import (
+	"container/list"
+	"crypto/cipher"
+	"encoding/binary"
+	"sync"
+)
+
+const cacheSizeDefault = 256
+
+// cachedCipher is a derived content encryption key.
+type cachedCipher struct {
+	gcm       cipher.AEAD
+	baseNonce nonce
+}
+
+// lruCache is a bounded least-recently-used cache, safe for concurrent use.
+type lruCache[V any] struct {
+	mu      sync.Mutex
+	size    int
+	entries *list.List
+	index   map[string]*list.Element
+}
+
+type lruEntry[V any] struct {
+	key   string
+	value V
+}
+
+func newLRUCache[V any](size int) *lruCache[V] {
+	if size <= 0 {
+		return nil
+	}
+	return &lruCache[V]{
+		size:    size,
+		entries: list.New(),
+		index:   make(map[string]*list.Element, size),
+	}
+}
+
+func (c *lruCache[V]) get(key string) (V, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if e, ok := c.index[key]; ok {
+		c.entries.MoveToFront(e)
+		return e.Value.(*lruEntry[V]).value, true
+	}
+	var zero V
+	return zero, false
+}
+
+func (c *lruCache[V]) add(key string, value V) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if e, ok := c.index[key]; ok {
+		c.entries.MoveToFront(e)
+		e.Value.(*lruEntry[V]).value = value
+		return
+	}
+	c.index[key] = c.entries.PushFront(&lruEntry[V]{key: key, value: value})
+	if c.entries.Len() > c.size {
+		oldest := c.entries.Back()
+		c.entries.Remove(oldest)
+		delete(c.index, oldest.Value.(*lruEntry[V]).key)
+	}
+}
+
+func (c *lruCache[V]) len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.entries.Len()
+}
+
+// cacheKey builds an unambiguous key from parts.
+func cacheKey(parts ...[]byte) string {
+	n := 0
+	for _, p := range parts {
+		n += 4 + len(p)
+	}
+	b := make([]byte, 0, n)
+	for _, p := range parts {
+		b = binary.BigEndian.AppendUint32(b, uint32(len(p)))
+		b = append(b, p...)
+	}
+	return string(b)
+}
@@ -0,0 +1,45 @@
+/*
+ * Copyright (c) 2019 Zenichi Amano
+ *
+ * This file is part of http-ece, which is MIT licensed.
+ * See http://opensource.org/licenses/MIT
+ */
+
+package httpece
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLRUCache(t *testing.T) {
+	c := newLRUCache[int](2)
+	c.add("a", 1)
+	c.add("b", 2)
+
+	v, ok := c.get("a")
+	assert.True(t, ok)
+	assert.Equal(t, 1, v)
+
+	// "b" is the least recently used.
+	c.add("c", 3)
+	assert.Equal(t, 2, c.len())
+	_, ok = c.get("b")
+	assert.False(t, ok)
+
+	c.add("a", 4)
+	v, ok = c.get("a")
+	assert.True(t, ok)
+	assert.Equal(t, 4, v)
+	assert.Equal(t, 2, c.len())
+}
+
+func TestLRUCache_Disabled(t *testing.T) {
+	assert.Nil(t, newLRUCache[int](0))
+}
+
+func TestCacheKey(t *testing.T) {
+	assert.NotEqual(t, cacheKey([]byte("ab"), []byte("c")), cacheKey([]byte("a"), []byte("bc")))
+	assert.Equal(t, cacheKey([]byte("ab"), []byte("c")), cacheKey([]byte("ab"), []byte("c")))
+}
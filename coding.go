@@ -0,0 +1,155 @@
+/*
+ * Copyright (c) 2019 Zenichi Amano
+ *
+ * This file is part of http-ece, which is MIT licensed.
+ * See http://opensource.org/licenses/MIT
+ */
+
+package httpece
+
+import (
+	"compress/gzip"
+	"compress/zlib"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+const (
+	codingGzip    = "gzip"
+	codingXGzip   = "x-gzip"
+	codingDeflate = "deflate"
+)
+
+// parseCodings returns the content codings of the header values in the order they were applied.
+// identity is left out.
+func parseCodings(values []string) []string {
+	var codings []string
+	for _, value := range values {
+		for coding := range strings.SplitSeq(value, ",") {
+			coding = strings.ToLower(strings.TrimSpace(coding))
+			if coding != "" && coding != codingIdentity {
+				codings = append(codings, coding)
+			}
+		}
+	}
+	return codings
+}
+
+// checkCodings reports an error for codings other than aes128gcm, gzip and deflate,
+// and for aes128gcm applied more than once.
+func checkCodings(codings []string) error {
+	encrypted := false
+	for _, coding := range codings {
+		switch coding {
+		case string(AES128GCM):
+			if encrypted {
+				return fmt.Errorf("%w: %s applied twice", ErrUnsupportedCoding, coding)
+			}
+			encrypted = true
+		case codingGzip, codingXGzip, codingDeflate:
+		default:
+			return fmt.Errorf("%w: %s", ErrUnsupportedCoding, coding)
+		}
+	}
+	return nil
+}
+
+// decodeReader returns a reader that removes the codings from r, the last applied first.
+// decrypt returns the reader that removes aes128gcm.
+func decodeReader(r io.Reader, codings []string, decrypt func(io.Reader) (io.Reader, error)) (io.Reader, error) {
+	if err := checkCodings(codings); err != nil {
+		return nil, err
+	}
+
+	for i := len(codings) - 1; i >= 0; i-- {
+		var err error
+		switch codings[i] {
+		case string(AES128GCM):
+			r, err = decrypt(r)
+		case codingGzip, codingXGzip:
+			r, err = gzip.NewReader(r)
+		case codingDeflate:
+			r, err = zlib.NewReader(r)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return r, nil
+}
+
+// encodeWriter returns a writer that applies the codings in order and writes the result to w.
+// encrypt returns the writer that applies aes128gcm.
+func encodeWriter(w io.Writer, codings []string, encrypt func(io.Writer) (io.WriteCloser, error)) (*codingWriter, error) {
+	if err := checkCodings(codings); err != nil {
+		return nil, err
+	}
+
+	cw := &codingWriter{writers: make([]io.WriteCloser, len(codings))}
+	for i := len(codings) - 1; i >= 0; i-- {
+		var wc io.WriteCloser
+		var err error
+		switch codings[i] {
+		case string(AES128GCM):
+			wc, err = encrypt(w)
+		case codingGzip, codingXGzip:
+			wc = gzip.NewWriter(w)
+		case codingDeflate:
+			wc = zlib.NewWriter(w)
+		}
+		if err != nil {
+			return nil, err
+		}
+		cw.writers[i] = wc
+		w = wc
+	}
+	cw.Writer = w
+	return cw, nil
+}
+
+// codingWriter writes through a chain of content codings.
+type codingWriter struct {
+	io.Writer
+	writers []io.WriteCloser // in the order the codings are applied
+}
+
+// Flush writes the data buffered by every coding.
+func (w *codingWriter) Flush() error {
+	for _, wc := range w.writers {
+		if f, ok := wc.(interface{ Flush() error }); ok {
+			if err := f.Flush(); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Close finishes every coding. The underlying writer is not closed.
+func (w *codingWriter) Close() error {
+	var errs []error
+	for _, wc := range w.writers {
+		errs = append(errs, wc.Close())
+	}
+	return errors.Join(errs...)
+}
+
+// pipeEncoded returns a reader of the data of src with the codings applied.
+// The codings are applied in a goroutine that stops when the reader is closed.
+func pipeEncoded(src io.ReadCloser, codings []string, encrypt func(io.Writer) (io.WriteCloser, error)) io.ReadCloser {
+	pr, pw := io.Pipe()
+	go func() {
+		defer src.Close()
+		cw, err := encodeWriter(pw, codings, encrypt)
+		if err == nil {
+			_, err = io.Copy(cw, src)
+			if e := cw.Close(); err == nil {
+				err = e
+			}
+		}
+		pw.CloseWithError(err)
+	}()
+	return pr
+}
@@ -0,0 +1,87 @@
+/*
+ * Copyright (c) 2019 Zenichi Amano
+ *
+ * This file is part of http-ece, which is MIT licensed.
+ * See http://opensource.org/licenses/MIT
+ */
+
+package httpece
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCodingChain(t *testing.T) {
+	key := []byte("0123456789abcdef")
+	plaintext := strings.Repeat("hello world ", 1000)
+	encrypt := func(w io.Writer) (io.WriteCloser, error) {
+		return NewEncryptWriter(w, WithKey(key))
+	}
+	decrypt := func(r io.Reader) (io.Reader, error) {
+		return NewDecryptReader(r, WithKey(key))
+	}
+
+	for _, codings := range [][]string{
+		{"aes128gcm"},
+		{"gzip", "aes128gcm"},
+		{"deflate", "aes128gcm"},
+		{"aes128gcm", "x-gzip"},
+		{"gzip", "deflate"},
+		nil,
+	} {
+		t.Run(strings.Join(codings, ","), func(t *testing.T) {
+			var buf bytes.Buffer
+			w, err := encodeWriter(&buf, codings, encrypt)
+			assert.Nil(t, err)
+			_, err = io.WriteString(w, plaintext[:100])
+			assert.Nil(t, err)
+			assert.Nil(t, w.Flush())
+			_, err = io.WriteString(w, plaintext[100:])
+			assert.Nil(t, err)
+			assert.Nil(t, w.Close())
+
+			r, err := decodeReader(bytes.NewReader(buf.Bytes()), codings, decrypt)
+			assert.Nil(t, err)
+			result, err := io.ReadAll(r)
+			assert.Nil(t, err)
+			assert.Equal(t, plaintext, string(result))
+
+			piped, err := io.ReadAll(pipeEncoded(io.NopCloser(strings.NewReader(plaintext)), codings, encrypt))
+			assert.Nil(t, err)
+			r, err = decodeReader(bytes.NewReader(piped), codings, decrypt)
+			assert.Nil(t, err)
+			result, err = io.ReadAll(r)
+			assert.Nil(t, err)
+			assert.Equal(t, plaintext, string(result))
+		})
+	}
+}
+
+func TestCheckCodings(t *testing.T) {
+	assert.Nil(t, checkCodings([]string{"gzip", "aes128gcm", "deflate"}))
+	assert.ErrorIs(t, checkCodings([]string{"br"}), ErrUnsupportedCoding)
+	assert.ErrorIs(t, checkCodings([]string{"aes128gcm", "gzip", "aes128gcm"}), ErrUnsupportedCoding)
+
+	_, err := decodeReader(strings.NewReader(""), []string{"compress"}, nil)
+	assert.EqualError(t, err, "unsupported content coding: compress")
+	_, err = encodeWriter(io.Discard, []string{"br"}, nil)
+	assert.ErrorIs(t, err, ErrUnsupportedCoding)
+}
+
+func TestParseCodings(t *testing.T) {
+	assert.Nil(t, parseCodings(nil))
+	assert.Equal(t, []string{"gzip", "aes128gcm"}, parseCodings([]string{"GZIP, identity", " aes128gcm ,"}))
+}
+
+func TestWithCompression(t *testing.T) {
+	_, err := parseOptions(encrypt, []Option{WithCompression("br")})
+	assert.ErrorIs(t, err, ErrUnsupportedCoding)
+	opt, err := parseOptions(encrypt, []Option{WithCompression("deflate")})
+	assert.Nil(t, err)
+	assert.Equal(t, "deflate", opt.compression)
+}
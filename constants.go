@@ -22,6 +22,7 @@ const (
 	keyLen            = aes.BlockSize
 	recodeSizeLen     = 4
 	nonceLen          = 12
+	tagLen            = 16
 	secretLen         = sha256.Size
 )
 
@@ -33,6 +34,13 @@ var (
 	ErrAllZeroPlaintext      = errors.New("all zero plaintext")
 	ErrUnableDetermineKey    = errors.New("unable to determine key")
 	ErrNoAuthSecret          = errors.New("no authentication secret for webpush")
+	ErrClosed                = errors.New("stream already closed")
+	ErrUnexpectedPadding     = errors.New("non-last record is padded")
+	ErrTrailingData          = errors.New("data after last record")
+	ErrShortFlush            = errors.New("too little buffered data to flush a record")
+	ErrUnsupportedCoding     = errors.New("unsupported content coding")
+	ErrUnsupportedDigest     = errors.New("unsupported digest algorithm")
+	ErrDigestMismatch        = errors.New("content digest mismatch")
 )
 
 var (
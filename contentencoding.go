@@ -35,10 +35,10 @@ func (i ContentEncoding) Padding() int {
 }
 
 // overhead return record overhead size
-func (i ContentEncoding) overhead(gcm cipher.AEAD) int {
+func (i ContentEncoding) overhead() int {
 	overhead := i.Padding()
 	if i == AES128GCM {
-		overhead += gcm.Overhead()
+		overhead += tagLen
 	}
 	return overhead
 }
@@ -77,27 +77,25 @@ func (i ContentEncoding) calculateCipherBlockEnd(gcm cipher.AEAD, start, content
 	return end, nil
 }
 
-// appendPadding
-func (i ContentEncoding) appendPadding(plaintext []byte, pad int, last bool) ([]byte, error) {
-	plaintextLen := len(plaintext)
-	result := make([]byte, plaintextLen+i.Padding()+pad)
-
+// appendPadding appends plaintext with pad bytes of padding to dst.
+func (i ContentEncoding) appendPadding(dst, plaintext []byte, pad int, last bool) ([]byte, error) {
 	switch i {
 	case AESGCM:
 		if pad < 0 || pad > math.MaxUint16 {
-			return nil, fmt.Errorf("padding size %d overflows: exceeds uint16 limit", pad)
+			return dst, fmt.Errorf("padding size %d overflows: exceeds uint16 limit", pad)
 		}
-		binary.BigEndian.PutUint16(result, uint16(pad))
-		copy(result[2+pad:], plaintext)
+		dst = binary.BigEndian.AppendUint16(dst, uint16(pad))
+		dst = append(dst, make([]byte, pad)...)
+		return append(dst, plaintext...), nil
 	default:
-		copy(result, plaintext)
+		dst = append(dst, plaintext...)
 		if last {
-			result[plaintextLen] = 0x02
+			dst = append(dst, 0x02)
 		} else {
-			result[plaintextLen] = 0x01
+			dst = append(dst, 0x01)
 		}
+		return append(dst, make([]byte, pad)...), nil
 	}
-	return result, nil
 }
 
 func (i ContentEncoding) unpad(plaintext []byte, last bool) ([]byte, error) {
@@ -120,6 +118,9 @@ func (i ContentEncoding) unpad(plaintext []byte, last bool) ([]byte, error) {
 		return nil, ErrAllZeroPlaintext
 	default:
 		padSize := i.Padding()
+		if len(plaintext) < padSize {
+			return nil, fmt.Errorf("record shorter than padding length: %d", len(plaintext))
+		}
 		switch padSize {
 		case 1:
 			padSize += int(plaintext[0])
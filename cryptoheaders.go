@@ -0,0 +1,347 @@
+/*
+ * Copyright (c) 2019 Zenichi Amano
+ *
+ * This file is part of http-ece, which is MIT licensed.
+ * See http://opensource.org/licenses/MIT
+ */
+
+package httpece
+
+import (
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+const (
+	headerEncryption = "Encryption"
+	headerCryptoKey  = "Crypto-Key"
+)
+
+// EncryptionParams is an entry of the Encryption header, which carries the salt of aesgcm content.
+type EncryptionParams struct {
+	KeyID      string
+	Salt       []byte
+	RecordSize uint32 // zero when absent
+}
+
+// CryptoKeyParams is an entry of the Crypto-Key header, which carries the keys of aesgcm content.
+type CryptoKeyParams struct {
+	KeyID     string
+	DH        []byte // public key of the sender
+	AESGCM    []byte // explicit key
+	P256ECDSA []byte // application server key for push services
+}
+
+// CryptoHeaders holds the Encryption and Crypto-Key headers that accompany aesgcm content.
+type CryptoHeaders struct {
+	Encryption []EncryptionParams
+	CryptoKey  []CryptoKeyParams
+}
+
+// ParseCryptoHeaders parses the Encryption and Crypto-Key headers of h.
+func ParseCryptoHeaders(h http.Header) (*CryptoHeaders, error) {
+	var c CryptoHeaders
+	var err error
+
+	if c.Encryption, err = ParseEncryption(strings.Join(h.Values(headerEncryption), ",")); err != nil {
+		return nil, err
+	}
+	if c.CryptoKey, err = ParseCryptoKey(strings.Join(h.Values(headerCryptoKey), ",")); err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
+
+// Set replaces the Encryption and Crypto-Key headers of h. Empty lists remove the header.
+func (c *CryptoHeaders) Set(h http.Header) {
+	h.Del(headerEncryption)
+	h.Del(headerCryptoKey)
+	if len(c.Encryption) > 0 {
+		h.Set(headerEncryption, FormatEncryption(c.Encryption...))
+	}
+	if len(c.CryptoKey) > 0 {
+		h.Set(headerCryptoKey, FormatCryptoKey(c.CryptoKey...))
+	}
+}
+
+// Lookup returns the Crypto-Key entry with the key identifier.
+func (c *CryptoHeaders) Lookup(keyID string) (CryptoKeyParams, bool) {
+	for _, p := range c.CryptoKey {
+		if p.KeyID == keyID {
+			return p, true
+		}
+	}
+	return CryptoKeyParams{}, false
+}
+
+// Options returns the options to decrypt aesgcm content with the first Encryption entry
+// and the Crypto-Key entry of the same key identifier.
+func (c *CryptoHeaders) Options() ([]Option, error) {
+	if len(c.Encryption) == 0 {
+		return nil, fmt.Errorf("missing %s header", headerEncryption)
+	}
+	enc := c.Encryption[0]
+	if len(enc.Salt) == 0 {
+		return nil, fmt.Errorf("missing salt in %s header", headerEncryption)
+	}
+
+	opts := []Option{WithEncoding(AESGCM), WithSalt(enc.Salt)}
+	if enc.RecordSize > 0 {
+		opts = append(opts, WithRecordSize(int(enc.RecordSize)))
+	}
+	if enc.KeyID != "" {
+		opts = append(opts, WithKeyID([]byte(enc.KeyID)))
+	}
+	if key, ok := c.Lookup(enc.KeyID); ok {
+		if key.DH != nil {
+			opts = append(opts, WithDh(key.DH))
+		}
+		if key.AESGCM != nil {
+			opts = append(opts, WithKey(key.AESGCM))
+		}
+	}
+	return opts, nil
+}
+
+// WithCryptoHeaders stores the Encryption and Crypto-Key headers of the content in dst
+// when encrypting with AESGCM, since its salt and key are not part of the content.
+// With an Encryptor, it is given per message.
+func WithCryptoHeaders(dst *CryptoHeaders) Option {
+	return func(opts *options) error {
+		opts.cryptoHeaders = dst
+		return nil
+	}
+}
+
+// storeCryptoHeaders stores the headers of the content into opt.cryptoHeaders.
+func storeCryptoHeaders(opt *options) {
+	if opt.cryptoHeaders == nil || opt.encoding != AESGCM {
+		return
+	}
+
+	enc := EncryptionParams{KeyID: string(opt.keyID), Salt: opt.salt}
+	if opt.recordSize != recordSizeDefault {
+		enc.RecordSize = opt.recordSize
+	}
+	*opt.cryptoHeaders = CryptoHeaders{Encryption: []EncryptionParams{enc}}
+	if opt.dh != nil {
+		opt.cryptoHeaders.CryptoKey = []CryptoKeyParams{{KeyID: enc.KeyID, DH: opt.publicKey.Bytes()}}
+	}
+}
+
+// ParseEncryption parses the value of an Encryption header.
+func ParseEncryption(value string) ([]EncryptionParams, error) {
+	entries, err := parseParams(value)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]EncryptionParams, 0, len(entries))
+	for _, entry := range entries {
+		var p EncryptionParams
+		for _, param := range entry {
+			switch param.name {
+			case "keyid":
+				p.KeyID = param.value
+			case "salt":
+				if p.Salt, err = decodeParam(param); err != nil {
+					return nil, err
+				}
+			case "rs":
+				rs, err := strconv.ParseUint(param.value, 10, 32)
+				if err != nil || rs > recordSizeMax {
+					return nil, fmt.Errorf("invalid parameter rs: %q", param.value)
+				}
+				p.RecordSize = uint32(rs)
+			}
+		}
+		result = append(result, p)
+	}
+	return result, nil
+}
+
+// ParseCryptoKey parses the value of a Crypto-Key header.
+func ParseCryptoKey(value string) ([]CryptoKeyParams, error) {
+	entries, err := parseParams(value)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]CryptoKeyParams, 0, len(entries))
+	for _, entry := range entries {
+		var p CryptoKeyParams
+		for _, param := range entry {
+			var dst *[]byte
+			switch param.name {
+			case "keyid":
+				p.KeyID = param.value
+				continue
+			case "dh":
+				dst = &p.DH
+			case "aesgcm":
+				dst = &p.AESGCM
+			case "p256ecdsa":
+				dst = &p.P256ECDSA
+			default:
+				continue
+			}
+			if *dst, err = decodeParam(param); err != nil {
+				return nil, err
+			}
+		}
+		result = append(result, p)
+	}
+	return result, nil
+}
+
+// FormatEncryption formats the value of an Encryption header.
+func FormatEncryption(entries ...EncryptionParams) string {
+	values := make([]string, len(entries))
+	for i, p := range entries {
+		var b paramsBuilder
+		b.string("keyid", p.KeyID)
+		b.bytes("salt", p.Salt)
+		if p.RecordSize > 0 {
+			b.add("rs", strconv.FormatUint(uint64(p.RecordSize), 10))
+		}
+		values[i] = b.String()
+	}
+	return strings.Join(values, ", ")
+}
+
+// FormatCryptoKey formats the value of a Crypto-Key header.
+func FormatCryptoKey(entries ...CryptoKeyParams) string {
+	values := make([]string, len(entries))
+	for i, p := range entries {
+		var b paramsBuilder
+		b.string("keyid", p.KeyID)
+		b.bytes("dh", p.DH)
+		b.bytes("aesgcm", p.AESGCM)
+		b.bytes("p256ecdsa", p.P256ECDSA)
+		values[i] = b.String()
+	}
+	return strings.Join(values, ", ")
+}
+
+type param struct {
+	name  string
+	value string
+}
+
+// parseParams parses comma separated entries of semicolon separated parameters.
+// Names are case-insensitive, and values are tokens or quoted strings.
+func parseParams(value string) ([][]param, error) {
+	var entries [][]param
+	var entry []param
+	s := value
+
+	for {
+		s = strings.TrimLeft(s, " \t")
+		if s == "" {
+			break
+		}
+		if s[0] == ',' || s[0] == ';' {
+			if s[0] == ',' && len(entry) > 0 {
+				entries = append(entries, entry)
+				entry = nil
+			}
+			s = s[1:]
+			continue
+		}
+
+		name, rest, ok := strings.Cut(s, "=")
+		if !ok || strings.ContainsAny(name, ",;\"") {
+			return nil, fmt.Errorf("invalid parameter list: %q", value)
+		}
+		name = strings.ToLower(strings.TrimSpace(name))
+		rest = strings.TrimLeft(rest, " \t")
+
+		var v string
+		if strings.HasPrefix(rest, `"`) {
+			if v, rest, ok = unquote(rest); !ok {
+				return nil, fmt.Errorf("invalid parameter list: %q", value)
+			}
+			if rest = strings.TrimLeft(rest, " \t"); rest != "" && rest[0] != ',' && rest[0] != ';' {
+				return nil, fmt.Errorf("invalid parameter list: %q", value)
+			}
+		} else {
+			end := strings.IndexAny(rest, ",;")
+			if end < 0 {
+				end = len(rest)
+			}
+			v, rest = strings.TrimSpace(rest[:end]), rest[end:]
+		}
+		for _, p := range entry {
+			if p.name == name {
+				return nil, fmt.Errorf("duplicate parameter %s", name)
+			}
+		}
+		entry = append(entry, param{name: name, value: v})
+		s = rest
+	}
+	if len(entry) > 0 {
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+// unquote returns the content of the quoted string at the start of s, and the rest of s.
+func unquote(s string) (string, string, bool) {
+	var b strings.Builder
+	for i := 1; i < len(s); i++ {
+		switch c := s[i]; c {
+		case '"':
+			return b.String(), s[i+1:], true
+		case '\\':
+			i++
+			if i == len(s) {
+				return "", "", false
+			}
+			b.WriteByte(s[i])
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return "", "", false
+}
+
+// decodeParam decodes a base64url parameter value with or without padding.
+func decodeParam(p param) ([]byte, error) {
+	b, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(p.value, "="))
+	if err != nil {
+		return nil, fmt.Errorf("invalid parameter %s: %w", p.name, err)
+	}
+	return b, nil
+}
+
+var quoteEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`)
+
+type paramsBuilder struct {
+	strings.Builder
+}
+
+func (b *paramsBuilder) add(name, value string) {
+	if b.Len() > 0 {
+		b.WriteByte(';')
+	}
+	b.WriteString(name)
+	b.WriteByte('=')
+	b.WriteString(value)
+}
+
+// string adds a quoted parameter unless value is empty.
+func (b *paramsBuilder) string(name, value string) {
+	if value != "" {
+		b.add(name, `"`+quoteEscaper.Replace(value)+`"`)
+	}
+}
+
+// bytes adds a base64url parameter unless value is empty.
+func (b *paramsBuilder) bytes(name string, value []byte) {
+	if len(value) > 0 {
+		b.add(name, base64.RawURLEncoding.EncodeToString(value))
+	}
+}
@@ -0,0 +1,126 @@
+/*
+ * Copyright (c) 2019 Zenichi Amano
+ *
+ * This file is part of http-ece, which is MIT licensed.
+ * See http://opensource.org/licenses/MIT
+ */
+
+package httpece
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCryptoHeaders(t *testing.T) {
+	authSecret := d(t, "9HcXsQe3xLMG/w2HsYKrOA==")
+	senderPrivateKey := d(t, "/oQYbac5yEOeOeg+5D0QxOaB1YtiyONxkqmxU3+tq58=")
+	receiverPrivateKey := d(t, "yfSYB+/vCEoWklHCG7F99cQ1vRwemFYn87jZc8PHBwU=")
+	receiverPublicKey := d(t, "BBixsHNhTqN5jYhpguokCWQhGKoZlroyEj6GYr6hy79z1IeTEQdupEsW6xooLvM170j9Ekss3amjhOmDNP5Pi0s=")
+	senderPublicKey := d(t, "BGJXZ4zDA04RfSgTufdauZXcNYbe3oF/yEri5ETSuZLDx70gYi7w2ytak8U82H01P1HYnIvr2fEeX7NZpeHdnhM=")
+
+	var headers CryptoHeaders
+	content, err := Encrypt([]byte("hello world"),
+		WithEncoding(AESGCM),
+		WithAuthSecret(authSecret),
+		WithPrivate(senderPrivateKey),
+		WithDh(receiverPublicKey),
+		WithKeyID([]byte("p256dh")),
+		WithRecordSize(100),
+		WithCryptoHeaders(&headers),
+	)
+	assert.Nil(t, err)
+	assert.Len(t, headers.Encryption, 1)
+	assert.Len(t, headers.Encryption[0].Salt, keyLen)
+	assert.Equal(t, senderPublicKey, headers.CryptoKey[0].DH)
+
+	h := http.Header{}
+	headers.Set(h)
+	assert.Regexp(t, `^keyid="p256dh";salt=[-_0-9A-Za-z]{22};rs=100$`, h.Get("Encryption"))
+	assert.Regexp(t, `^keyid="p256dh";dh=[-_0-9A-Za-z]{87}$`, h.Get("Crypto-Key"))
+
+	parsed, err := ParseCryptoHeaders(h)
+	assert.Nil(t, err)
+	assert.Equal(t, &headers, parsed)
+	opts, err := parsed.Options()
+	assert.Nil(t, err)
+	plaintext, err := Decrypt(content, append(opts, WithAuthSecret(authSecret), WithPrivate(receiverPrivateKey))...)
+	assert.Nil(t, err)
+	assert.Equal(t, "hello world", string(plaintext))
+}
+
+func TestCryptoHeaders_AES128GCM(t *testing.T) {
+	headers := CryptoHeaders{Encryption: []EncryptionParams{{KeyID: "a"}}}
+	_, err := Encrypt([]byte("test"), WithKey([]byte("0123456789abcdef")), WithCryptoHeaders(&headers))
+	assert.Nil(t, err)
+	// The content carries its own header.
+	assert.Equal(t, "a", headers.Encryption[0].KeyID)
+}
+
+func TestCryptoHeaders_Options(t *testing.T) {
+	h := http.Header{}
+	h.Add("Encryption", `keyid="b";salt="mRGYnIzSJGeZnJ19lgQcfw==";rs=24`)
+	h.Add("Crypto-Key", `keyid=a;dh=AQ, keyid="b"; aesgcm=MDEyMzQ1Njc4OWFiY2RlZg, p256ecdsa=Ag`)
+	headers, err := ParseCryptoHeaders(h)
+	assert.Nil(t, err)
+	assert.Equal(t, []CryptoKeyParams{
+		{KeyID: "a", DH: []byte{1}},
+		{KeyID: "b", AESGCM: []byte("0123456789abcdef")},
+		{P256ECDSA: []byte{2}},
+	}, headers.CryptoKey)
+
+	opts, err := headers.Options()
+	assert.Nil(t, err)
+	content, err := Encrypt([]byte("test"), opts...)
+	assert.Nil(t, err)
+	plaintext, err := Decrypt(content, opts...)
+	assert.Nil(t, err)
+	assert.Equal(t, "test", string(plaintext))
+
+	_, err = (&CryptoHeaders{}).Options()
+	assert.EqualError(t, err, "missing Encryption header")
+	_, err = (&CryptoHeaders{Encryption: []EncryptionParams{{KeyID: "a"}}}).Options()
+	assert.EqualError(t, err, "missing salt in Encryption header")
+}
+
+func TestParseEncryption(t *testing.T) {
+	tests := []struct {
+		value    string
+		expected []EncryptionParams
+		err      string
+	}{
+		{value: "", expected: []EncryptionParams{}},
+		{value: `salt=AQ`, expected: []EncryptionParams{{Salt: []byte{1}}}},
+		{value: `KeyID="a\"b" ; Salt=AQ==, rs=10`, expected: []EncryptionParams{{KeyID: `a"b`, Salt: []byte{1}}, {RecordSize: 10}}},
+		{value: `other="x,y";salt=AQ`, expected: []EncryptionParams{{Salt: []byte{1}}}},
+		{value: `salt=AQ;salt=Ag`, err: "duplicate parameter salt"},
+		{value: `salt=+/`, err: "invalid parameter salt: illegal base64 data at input byte 0"},
+		{value: `rs=-1`, err: `invalid parameter rs: "-1"`},
+		{value: `salt`, err: `invalid parameter list: "salt"`},
+		{value: `keyid="a`, err: `invalid parameter list: "keyid=\"a"`},
+		{value: `keyid="a"b`, err: `invalid parameter list: "keyid=\"a\"b"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			params, err := ParseEncryption(tt.value)
+			if tt.err != "" {
+				assert.EqualError(t, err, tt.err)
+				return
+			}
+			assert.Nil(t, err)
+			assert.Equal(t, tt.expected, params)
+		})
+	}
+}
+
+func TestFormatEncryption(t *testing.T) {
+	assert.Equal(t, `keyid="a\"b";salt=AQ, rs=10`, FormatEncryption(
+		EncryptionParams{KeyID: `a"b`, Salt: []byte{1}},
+		EncryptionParams{RecordSize: 10},
+	))
+	assert.Equal(t, `keyid="a";dh=AQ;p256ecdsa=Ag`, FormatCryptoKey(
+		CryptoKeyParams{KeyID: "a", DH: []byte{1}, P256ECDSA: []byte{2}},
+	))
+}
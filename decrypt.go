@@ -9,10 +9,24 @@ package httpece
 
 import (
 	"crypto/cipher"
-	"encoding/binary"
 	"fmt"
+	"slices"
 )
 
+// RecordError reports a record that cannot be decrypted.
+type RecordError struct {
+	Index int64 // Index of the record, starting at 0
+	Err   error // Cause, e.g. an authentication failure or ErrTruncated
+}
+
+func (e *RecordError) Error() string {
+	return fmt.Sprintf("record %d: %v", e.Index, e.Err)
+}
+
+func (e *RecordError) Unwrap() error {
+	return e.Err
+}
+
 // Decrypt decrypts content data.
 func Decrypt(content []byte, opts ...Option) ([]byte, error) {
 	var opt *options
@@ -23,78 +37,180 @@ func Decrypt(content []byte, opts ...Option) ([]byte, error) {
 		return nil, err
 	}
 
-	content = readHeader(opt, content)
+	return decryptContent(nil, opt, content)
+}
 
-	// Derive key and nonce.
-	key, baseNonce, err := deriveKeyAndNonce(opt)
+// DecryptAppend decrypts content data and appends the plaintext to dst.
+// The output is sized once and records are opened without intermediate buffers.
+// dst and content must not overlap.
+func DecryptAppend(dst, content []byte, opts ...Option) ([]byte, error) {
+	var opt *options
+	var err error
+
+	// Options
+	if opt, err = parseOptions(decrypt, opts); err != nil {
+		return dst, err
+	}
+
+	return decryptContent(dst, opt, content)
+}
+
+func decryptContent(dst []byte, opt *options, content []byte) ([]byte, error) {
+	digests := newCiphertextDigests(opt)
+	_, _ = digests.Write(content)
+	if err := digests.check(); err != nil {
+		return dst, err
+	}
+
+	content, err := readHeader(opt, content)
 	if err != nil {
-		return nil, err
+		return dst, err
+	}
+
+	state, err := newDecryptState(opt)
+	if err != nil {
+		return dst, err
+	}
+
+	// Decrypt records.
+	if opt.workers > 1 {
+		return state.openParallel(dst, content, opt.workers)
 	}
+	return state.openRecords(dst, content)
+}
+
+// decryptState holds the per-message state shared by all records.
+type decryptState struct {
+	opt       *options
+	gcm       cipher.AEAD
+	baseNonce nonce
+	blockSize int // ciphertext size of a full record
+	counter   uint32
+	done      bool
+	nonce     [nonceLen]byte
+}
 
-	gcm, err := createCipher(key)
+func newDecryptState(opt *options) (*decryptState, error) {
+	// Derive key and nonce.
+	gcm, baseNonce, err := createCipherAndNonce(opt)
 	if err != nil {
 		return nil, err
 	}
 
 	// Check Record Size
-	overhead := opt.encoding.overhead(gcm)
+	overhead := opt.encoding.overhead()
 	recordSize := int(opt.recordSize)
 	if recordSize < overhead {
 		return nil, fmt.Errorf("recordSize has to be greater than %d", overhead)
 	}
 
-	// Calculate chunkSize.
+	blockSize := recordSize
+	if opt.encoding != AES128GCM {
+		blockSize += gcm.Overhead()
+	}
+
+	return &decryptState{
+		opt:       opt,
+		gcm:       gcm,
+		baseNonce: baseNonce,
+		blockSize: blockSize,
+	}, nil
+}
+
+// openRecords decrypts all records of content and appends the plaintext to dst.
+func (s *decryptState) openRecords(dst, content []byte) ([]byte, error) {
 	var (
-		baseRecordSize = recordSize - overhead
-		start          = 0
-		counter        = uint32(0)
-		contentLen     = len(content)
-		recordNum      = (contentLen + baseRecordSize - 1) / baseRecordSize
+		opt        = s.opt
+		start      = 0
+		contentLen = len(content)
 	)
 
-	// Decrypt records.
-	results := make([][]byte, 0, recordNum)
-	for start < contentLen {
-		end, err := opt.encoding.calculateCipherBlockEnd(gcm, start, contentLen, opt.recordSize)
+	results := slices.Grow(dst, contentLen)
+	for !s.done {
+		end, err := opt.encoding.calculateCipherBlockEnd(s.gcm, start, contentLen, opt.recordSize)
 		if err != nil {
-			return nil, err
+			return dst, err
 		}
 		last := end == contentLen
-		// Generate nonce.
-		nonce := generateNonce(baseNonce, counter)
-		debug.dumpBinary("nonce", nonce)
-		r, err := decryptRecord(opt, gcm, nonce, content[start:end], last)
+		results, err = s.openRecord(results, content[start:end], last)
 		if err != nil {
-			return nil, err
+			return dst, err
 		}
-		results = append(results, r)
-		debug.dumpBinary("result", r)
 		start = end
-		counter++
 	}
-	return join(results), nil
+	return results, nil
 }
 
-func readHeader(opt *options, content []byte) []byte {
-	if opt.encoding == AES128GCM {
-		baseOffset := uint32(keyLen + recodeSizeLen)
-		idLen := uint32(content[baseOffset])
+// openRecord decrypts the next record and appends its plaintext to dst.
+func (s *decryptState) openRecord(dst, record []byte, last bool) ([]byte, error) {
+	dst, err := s.openRecordAt(dst, record, s.counter, last)
+	if err != nil {
+		return dst, err
+	}
+	s.counter++
+	s.done = last
+	return dst, nil
+}
 
-		opt.salt = content[0:keyLen]
-		opt.recordSize = binary.BigEndian.Uint32(content[keyLen:baseOffset])
-		baseOffset++
-		opt.keyID = content[baseOffset : baseOffset+idLen]
+// openFullRecord decrypts the next record of full size and appends its plaintext to dst.
+// Whether it is the last record is taken from its padding delimiter, so no data after it has to be read.
+func (s *decryptState) openFullRecord(dst, record []byte) ([]byte, bool, error) {
+	if s.opt.encoding != AES128GCM {
+		// A full record is never the last one.
+		dst, err := s.openRecord(dst, record, false)
+		return dst, false, err
+	}
 
-		return content[baseOffset+idLen:]
+	offset := len(dst)
+	result, err := s.open(dst, record, s.counter)
+	if err != nil {
+		return dst, false, &RecordError{Index: int64(s.counter), Err: err}
+	}
+	last := true
+	plaintext, err := s.opt.encoding.unpad(result[offset:], last)
+	if err == ErrInvalidPaddingLast {
+		last = false
+		plaintext, err = s.opt.encoding.unpad(result[offset:], last)
 	}
-	return content
+	if err != nil {
+		return dst, false, &RecordError{Index: int64(s.counter), Err: err}
+	}
+	s.counter++
+	s.done = last
+	return result[:offset+copy(result[offset:], plaintext)], last, nil
 }
 
-func decryptRecord(opt *options, gcm cipher.AEAD, nonce []byte, content []byte, last bool) ([]byte, error) {
-	result, err := gcm.Open(nil, nonce, content, nil)
+// openRecordAt decrypts the record with index counter and appends its plaintext to dst.
+// Failures are reported as a RecordError.
+func (s *decryptState) openRecordAt(dst, record []byte, counter uint32, last bool) ([]byte, error) {
+	offset := len(dst)
+	result, err := s.open(dst, record, counter)
 	if err != nil {
-		return nil, err
+		return dst, &RecordError{Index: int64(counter), Err: err}
 	}
+	plaintext, err := s.opt.encoding.unpad(result[offset:], last)
+	if err == ErrInvalidPaddingLast {
+		// A valid non-last record at the end means the content was cut off.
+		if _, e := s.opt.encoding.unpad(result[offset:], false); e == nil {
+			err = ErrTruncated
+		}
+	} else if err == ErrInvalidPaddingNonLast {
+		// A valid last record followed by more data.
+		if _, e := s.opt.encoding.unpad(result[offset:], true); e == nil {
+			err = ErrTrailingData
+		}
+	}
+	if err != nil {
+		return dst, &RecordError{Index: int64(counter), Err: err}
+	}
+	debug.dumpBinary("result", plaintext)
+	return result[:offset+copy(result[offset:], plaintext)], nil
+}
 
-	return opt.encoding.unpad(result, last)
+// open decrypts the record with index counter and appends the padded plaintext to dst.
+func (s *decryptState) open(dst, record []byte, counter uint32) ([]byte, error) {
+	// Generate nonce.
+	putNonce(s.nonce[:], s.baseNonce, counter)
+	debug.dumpBinary("nonce", s.nonce[:])
+	return s.gcm.Open(dst, s.nonce[:], record, nil)
 }
@@ -0,0 +1,108 @@
+/*
+ * Copyright (c) 2019 Zenichi Amano
+ *
+ * This file is part of http-ece, which is MIT licensed.
+ * See http://opensource.org/licenses/MIT
+ */
+
+package httpece
+
+import (
+	"slices"
+)
+
+// decryptJob is a run of records opened by a worker.
+type decryptJob struct {
+	counter uint32 // index of the first record
+	start   int    // content offset of the first record
+	end     int    // content offset after the last record
+	n       int    // plaintext size
+}
+
+// openParallel decrypts all records of content and appends the plaintext to dst, like openRecords.
+// The records are opened by up to workers goroutines.
+// When records fail, the error of the lowest failing record is returned, as openRecords would.
+func (s *decryptState) openParallel(dst, content []byte, workers int) ([]byte, error) {
+	var (
+		opt        = s.opt
+		perJob     = max(1, parallelChunkSize/s.blockSize)
+		contentLen = len(content)
+		jobs       []decryptJob
+		layoutErr  error
+		start      = 0
+		counter    = s.counter
+	)
+
+	// Split the content on record boundaries.
+	for done := false; !done; {
+		job := decryptJob{counter: counter, start: start}
+		for i := 0; i < perJob && !done; i++ {
+			end, err := opt.encoding.calculateCipherBlockEnd(s.gcm, start, contentLen, opt.recordSize)
+			if err != nil {
+				layoutErr = err
+				break
+			}
+			done = end == contentLen
+			start = end
+			counter++
+		}
+		job.end = start
+		if job.end > job.start {
+			jobs = append(jobs, job)
+		}
+		if layoutErr != nil {
+			break
+		}
+	}
+
+	if len(jobs) < 2 {
+		return s.openRecords(dst, content)
+	}
+
+	// Each job writes its plaintext at the offset of its ciphertext, which is an upper bound.
+	base := len(dst)
+	out := slices.Grow(dst, contentLen)[:base+contentLen]
+	errs := make([]error, len(jobs))
+	runParallel(len(jobs), workers, func(i int) {
+		errs[i] = jobs[i].open(*s, out[base+jobs[i].start:base+jobs[i].start], content)
+	})
+	for _, err := range errs {
+		if err != nil {
+			return dst, err
+		}
+	}
+	if layoutErr != nil {
+		return dst, layoutErr
+	}
+
+	// Move the plaintext together.
+	n := base
+	for _, job := range jobs {
+		n += copy(out[n:], out[base+job.start:base+job.start+job.n])
+	}
+
+	s.counter = counter
+	s.done = true
+	return out[:n], nil
+}
+
+// open decrypts the records of the job into dst, which has room for them.
+func (j *decryptJob) open(state decryptState, dst, content []byte) error {
+	var (
+		opt        = state.opt
+		start      = j.start
+		contentLen = len(content)
+		err        error
+	)
+
+	state.counter = j.counter
+	for start < j.end {
+		end, _ := opt.encoding.calculateCipherBlockEnd(state.gcm, start, contentLen, opt.recordSize)
+		if dst, err = state.openRecord(dst, content[start:end], end == contentLen); err != nil {
+			return err
+		}
+		start = end
+	}
+	j.n = len(dst)
+	return nil
+}
@@ -0,0 +1,112 @@
+/*
+ * Copyright (c) 2019 Zenichi Amano
+ *
+ * This file is part of http-ece, which is MIT licensed.
+ * See http://opensource.org/licenses/MIT
+ */
+
+package httpece
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"runtime"
+	"slices"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDecryptParallel(t *testing.T) {
+	key := []byte("0123456789abcdef")
+	salt := d(t, "mRGYnIzSJGeZnJ19lgQcfw==")
+	plaintext := make([]byte, 1<<20)
+	for i := range plaintext {
+		plaintext[i] = byte(i)
+	}
+
+	for _, encoding := range []ContentEncoding{AES128GCM, AESGCM} {
+		for _, size := range []int{0, 100, 1 << 20} {
+			for _, recordSize := range []int{55, 4096} {
+				for _, padSize := range []int{0, 300000} {
+					if padSize > size {
+						continue
+					}
+					t.Run(fmt.Sprintf("%s/%d/%d/%d", encoding, size, recordSize, padSize), func(t *testing.T) {
+						opts := []Option{WithEncoding(encoding), WithKey(key), WithSalt(salt), WithRecordSize(recordSize)}
+						content, err := Encrypt(plaintext[:size], append(opts, WithPadSize(padSize))...)
+						assert.Nil(t, err)
+
+						result, err := DecryptAppend([]byte("prefix"), content, append(opts, WithConcurrency(4))...)
+						assert.Nil(t, err)
+						assert.Equal(t, "prefix", string(result[:6]))
+						assert.True(t, bytes.Equal(plaintext[:size], result[6:]))
+					})
+				}
+			}
+		}
+	}
+}
+
+func TestDecryptParallel_Errors(t *testing.T) {
+	key := []byte("0123456789abcdef")
+	opts := []Option{WithKey(key), WithKeyID([]byte("a1")), WithRecordSize(100)}
+	content, err := Encrypt(make([]byte, 1<<20), opts...)
+	assert.Nil(t, err)
+	headerLen := keyLen + recodeSizeLen + 1 + 2
+	records := (len(content) - headerLen + 99) / 100
+
+	corrupt := func(indexes ...int) []byte {
+		c := bytes.Clone(content)
+		for _, i := range indexes {
+			c[headerLen+i*100] ^= 1
+		}
+		return c
+	}
+
+	f := func(c []byte) error {
+		expected, expectedErr := Decrypt(c, opts...)
+		result, err := Decrypt(c, append(opts, WithConcurrency(4))...)
+		assert.Equal(t, expectedErr, err)
+		assert.Equal(t, expected, result)
+		return err
+	}
+
+	// Authentication failures in several records report the lowest one.
+	for _, indexes := range [][]int{{records - 1}, {5000, records - 1}, {9000, 5000}} {
+		err := f(corrupt(indexes...))
+		var recordErr *RecordError
+		if assert.True(t, errors.As(err, &recordErr), err) {
+			assert.Equal(t, int64(slices.Min(indexes)), recordErr.Index)
+			assert.EqualError(t, recordErr.Err, "cipher: message authentication failed")
+		}
+	}
+
+	// A record cut off inside the tag at the end.
+	f(content[:len(content)-70])
+
+	// Content cut at a record boundary fails the delimiter check of the last record.
+	c := content[:headerLen+(records-1)*100]
+	_, err = Decrypt(c, append(opts, WithConcurrency(4))...)
+	assert.ErrorIs(t, err, ErrTruncated)
+	f(c)
+}
+
+func BenchmarkDecryptParallel(b *testing.B) {
+	key := []byte("0123456789abcdef")
+	content, err := Encrypt(make([]byte, 64<<20), WithKey(key))
+	assert.Nil(b, err)
+	dst := make([]byte, 0, len(content))
+
+	b.ReportAllocs()
+	b.SetBytes(int64(len(content)))
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		_, err = DecryptAppend(dst[:0], content, WithKey(key), WithConcurrency(runtime.GOMAXPROCS(0)))
+	}
+	b.StopTimer()
+
+	assert.Nil(b, err)
+}
@@ -0,0 +1,119 @@
+/*
+ * Copyright (c) 2019 Zenichi Amano
+ *
+ * This file is part of http-ece, which is MIT licensed.
+ * See http://opensource.org/licenses/MIT
+ */
+
+package httpece
+
+import (
+	"errors"
+	"io"
+	"slices"
+)
+
+type decryptReader struct {
+	r     io.Reader
+	state *decryptState
+	buf   []byte // one ciphertext record
+	plain []byte // decrypted record
+	out   []byte // unread part of plain
+	err   error
+}
+
+// NewDecryptReader returns a reader that decrypts content read from r.
+// The header is read before returning, so that key errors are reported early.
+// Only one record is held in memory at a time.
+func NewDecryptReader(r io.Reader, opts ...Option) (io.Reader, error) {
+	var opt *options
+	var err error
+
+	// Options
+	if opt, err = parseOptions(decrypt, opts); err != nil {
+		return nil, err
+	}
+
+	return newDecryptReader(r, opt)
+}
+
+func newDecryptReader(r io.Reader, opt *options) (io.Reader, error) {
+	r = ciphertextReader(r, opt)
+	if err := readHeaderFrom(opt, r); err != nil {
+		return nil, err
+	}
+
+	state, err := newDecryptState(opt)
+	if err != nil {
+		return nil, err
+	}
+
+	return &decryptReader{
+		r:     r,
+		state: state,
+	}, nil
+}
+
+func (r *decryptReader) Read(p []byte) (int, error) {
+	for len(r.out) == 0 {
+		if r.err != nil {
+			return 0, r.err
+		}
+		r.err = r.next()
+	}
+	n := copy(p, r.out)
+	r.out = r.out[n:]
+	return n, nil
+}
+
+// next decrypts the next record.
+func (r *decryptReader) next() error {
+	if r.state.done {
+		// Nothing may follow the last record.
+		if n, err := io.ReadFull(r.r, r.buf[:cap(r.buf)][:1]); n > 0 {
+			return ErrTrailingData
+		} else if !errors.Is(err, io.EOF) {
+			return err
+		}
+		return io.EOF
+	}
+
+	// A record is decrypted as soon as it is complete, so that flushed records are not held back.
+	var err error
+	blockSize := r.state.blockSize
+	if r.buf, err = readAtMost(r.r, r.buf[:0], blockSize); err != nil && !errors.Is(err, io.EOF) {
+		return err
+	}
+	total := len(r.buf)
+
+	if total == blockSize {
+		r.plain, _, err = r.state.openFullRecord(r.plain[:0], r.buf)
+	} else {
+		opt := r.state.opt
+		if _, err = opt.encoding.calculateCipherBlockEnd(r.state.gcm, 0, total, opt.recordSize); err != nil {
+			return err
+		}
+		r.plain, err = r.state.openRecord(r.plain[:0], r.buf, true)
+	}
+	if err != nil {
+		return err
+	}
+	r.out = r.plain
+	return nil
+}
+
+// readAtMost appends data read from r to buf until buf holds n bytes or r ends with io.EOF.
+// buf grows with the data, so that a large record size from the header does not allocate up front.
+func readAtMost(r io.Reader, buf []byte, n int) ([]byte, error) {
+	for len(buf) < n {
+		if len(buf) == cap(buf) {
+			buf = slices.Grow(buf, min(n-len(buf), max(len(buf), 512)))
+		}
+		m, err := r.Read(buf[len(buf):min(cap(buf), n)])
+		buf = buf[:len(buf)+m]
+		if err != nil {
+			return buf, err
+		}
+	}
+	return buf, nil
+}
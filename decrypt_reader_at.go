@@ -0,0 +1,286 @@
+/*
+ * Copyright (c) 2019 Zenichi Amano
+ *
+ * This file is part of http-ece, which is MIT licensed.
+ * See http://opensource.org/licenses/MIT
+ */
+
+package httpece
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"slices"
+	"sort"
+	"sync"
+)
+
+// DecryptReaderAt decrypts content stored in an io.ReaderAt, reading only the records needed.
+// It implements io.ReaderAt and io.ReadSeeker over the plaintext.
+//
+// Records hold a fixed amount of ciphertext, but padding makes the amount of plaintext vary.
+// Unless WithUnpaddedRecords is given, the records in front of an offset are decrypted once to locate it;
+// with it, the content has to be free of padding, or else offsets and the size are wrong.
+type DecryptReaderAt struct {
+	mu         sync.Mutex
+	r          io.ReaderAt
+	state      *decryptState
+	dataOffset int64   // offset of the first record
+	records    int64   // number of records
+	lastLen    int     // ciphertext size of the last record
+	fullLen    int64   // plaintext size of an unpadded record
+	ends       []int64 // plaintext end offsets of the records indexed so far
+	cached     int64   // index of the record in plain
+	buf        []byte  // ciphertext of a record
+	plain      []byte  // plaintext of the cached record
+	offset     int64   // offset for Read and Seek
+}
+
+// NewDecryptReaderAt returns a DecryptReaderAt that decrypts size bytes of content read from r.
+// The header is read before returning.
+func NewDecryptReaderAt(r io.ReaderAt, size int64, opts ...Option) (*DecryptReaderAt, error) {
+	var opt *options
+	var err error
+
+	// Options
+	if opt, err = parseOptions(decrypt, opts); err != nil {
+		return nil, err
+	}
+
+	return newDecryptReaderAt(r, size, opt)
+}
+
+func newDecryptReaderAt(r io.ReaderAt, size int64, opt *options) (*DecryptReaderAt, error) {
+	sr := io.NewSectionReader(r, 0, size)
+	if err := readHeaderFrom(opt, sr); err != nil {
+		return nil, err
+	}
+	dataOffset, _ := sr.Seek(0, io.SeekCurrent)
+
+	state, err := newDecryptState(opt)
+	if err != nil {
+		return nil, err
+	}
+
+	overhead := state.gcm.Overhead() + opt.encoding.Padding()
+	if state.blockSize <= overhead {
+		return nil, fmt.Errorf("recordSize has to be greater than %d", opt.encoding.overhead())
+	}
+
+	var (
+		blockSize  = int64(state.blockSize)
+		contentLen = size - dataOffset
+		records    = (contentLen + blockSize - 1) / blockSize
+	)
+	if contentLen <= 0 {
+		return nil, ErrTruncated
+	}
+	if records > math.MaxUint32+1 {
+		return nil, fmt.Errorf("too many records %d", records)
+	}
+	lastLen := int(contentLen - (records-1)*blockSize)
+	if _, err = opt.encoding.calculateCipherBlockEnd(state.gcm, 0, lastLen, opt.recordSize); err != nil {
+		return nil, err
+	}
+
+	return &DecryptReaderAt{
+		r:          r,
+		state:      state,
+		dataOffset: dataOffset,
+		records:    records,
+		lastLen:    lastLen,
+		fullLen:    int64(state.blockSize - overhead),
+		cached:     -1,
+		buf:        make([]byte, min(blockSize, contentLen)),
+	}, nil
+}
+
+// ReadAt reads len(p) bytes of plaintext starting at offset off.
+func (r *DecryptReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, errors.New("negative offset")
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.readAt(p, off)
+}
+
+// Read reads plaintext from the current offset.
+func (r *DecryptReaderAt) Read(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	n, err := r.readAt(p, r.offset)
+	r.offset += int64(n)
+	if n > 0 && errors.Is(err, io.EOF) {
+		err = nil
+	}
+	return n, err
+}
+
+// Seek sets the offset for the next Read.
+// Seeking relative to the end needs the plaintext size, see Size.
+func (r *DecryptReaderAt) Seek(offset int64, whence int) (int64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += r.offset
+	case io.SeekEnd:
+		size, err := r.size()
+		if err != nil {
+			return 0, err
+		}
+		offset += size
+	default:
+		return 0, errors.New("invalid whence")
+	}
+	if offset < 0 {
+		return 0, errors.New("negative position")
+	}
+	r.offset = offset
+	return offset, nil
+}
+
+// Size returns the plaintext size.
+// Unless WithUnpaddedRecords is given, all records are decrypted the first time.
+func (r *DecryptReaderAt) Size() (int64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.size()
+}
+
+func (r *DecryptReaderAt) size() (int64, error) {
+	last := r.records - 1
+	if r.state.opt.unpadded {
+		plain, err := r.record(last)
+		if err != nil {
+			return 0, err
+		}
+		return last*r.fullLen + int64(len(plain)), nil
+	}
+	if err := r.index(last); err != nil {
+		return 0, err
+	}
+	return r.ends[last], nil
+}
+
+// setIndex takes the plaintext end offsets of all records from an earlier reader of the same content.
+func (r *DecryptReaderAt) setIndex(ends []int64) {
+	if int64(len(ends)) == r.records {
+		r.ends = ends
+	}
+}
+
+// fullIndex returns the plaintext end offsets of all records, or nil if not every record is indexed.
+// The result must not be modified.
+func (r *DecryptReaderAt) fullIndex() []int64 {
+	if int64(len(r.ends)) != r.records {
+		return nil
+	}
+	return slices.Clip(r.ends)
+}
+
+func (r *DecryptReaderAt) readAt(p []byte, off int64) (int, error) {
+	n := 0
+	for n < len(p) {
+		plain, start, err := r.recordAt(off + int64(n))
+		if err != nil {
+			return n, err
+		}
+		n += copy(p[n:], plain[off+int64(n)-start:])
+	}
+	return n, nil
+}
+
+// recordAt returns the plaintext of the record holding offset off, and the offset of the record.
+func (r *DecryptReaderAt) recordAt(off int64) ([]byte, int64, error) {
+	var i, start int64
+	if r.state.opt.unpadded {
+		i = min(off/r.fullLen, r.records-1)
+		start = i * r.fullLen
+	} else {
+		// Index records until one ends after off.
+		for len(r.ends) < int(r.records) && (len(r.ends) == 0 || r.ends[len(r.ends)-1] <= off) {
+			if err := r.index(int64(len(r.ends))); err != nil {
+				return nil, 0, err
+			}
+		}
+		i = int64(sort.Search(len(r.ends), func(j int) bool { return r.ends[j] > off }))
+		if i == int64(len(r.ends)) {
+			return nil, 0, io.EOF
+		}
+		if i > 0 {
+			start = r.ends[i-1]
+		}
+	}
+
+	plain, err := r.record(i)
+	if err != nil {
+		return nil, 0, err
+	}
+	if off-start >= int64(len(plain)) {
+		return nil, 0, io.EOF
+	}
+	return plain, start, nil
+}
+
+// index decrypts records until the end of record i is known.
+func (r *DecryptReaderAt) index(i int64) error {
+	for j := int64(len(r.ends)); j <= i; j++ {
+		plain, err := r.record(j)
+		if err != nil {
+			return err
+		}
+		end := int64(len(plain))
+		if j > 0 {
+			end += r.ends[j-1]
+		}
+		r.ends = append(r.ends, end)
+	}
+	return nil
+}
+
+// record returns the plaintext of record i.
+func (r *DecryptReaderAt) record(i int64) ([]byte, error) {
+	if i == r.cached {
+		return r.plain, nil
+	}
+
+	last := i == r.records-1
+	blockSize := int64(r.state.blockSize)
+	buf := r.buf
+	if last {
+		buf = buf[:r.lastLen]
+	}
+	if n, err := r.r.ReadAt(buf, r.dataOffset+i*blockSize); n < len(buf) {
+		return nil, truncatedError(err)
+	}
+
+	r.cached = -1
+	plain, err := r.state.openRecordAt(r.plain[:0], buf, uint32(i), last) //nolint:gosec // checked against math.MaxUint32 in newDecryptReaderAt
+	r.plain = plain
+	if err != nil {
+		return nil, err
+	}
+	if r.state.opt.unpadded && !last && int64(len(plain)) != r.fullLen {
+		return nil, ErrUnexpectedPadding
+	}
+	r.cached = i
+	return plain, nil
+}
+
+// truncatedError reports an early end of the content as ErrTruncated.
+func truncatedError(err error) error {
+	if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return ErrTruncated
+	}
+	return err
+}
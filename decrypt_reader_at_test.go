@@ -0,0 +1,116 @@
+/*
+ * Copyright (c) 2019 Zenichi Amano
+ *
+ * This file is part of http-ece, which is MIT licensed.
+ * See http://opensource.org/licenses/MIT
+ */
+
+package httpece
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"testing"
+	"testing/iotest"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDecryptReaderAt(t *testing.T) {
+	key := []byte("0123456789abcdef")
+	salt := d(t, "mRGYnIzSJGeZnJ19lgQcfw==")
+	plaintext := make([]byte, 1000)
+	for i := range plaintext {
+		plaintext[i] = byte(i)
+	}
+
+	for _, encoding := range []ContentEncoding{AES128GCM, AESGCM} {
+		for _, size := range []int{1, 37, 38, 39, 1000} {
+			for _, padSize := range []int{0, 1, 30} {
+				for _, unpadded := range []bool{false, true} {
+					if unpadded && padSize > 0 {
+						continue
+					}
+					t.Run(fmt.Sprintf("%s/%d/%d/%t", encoding, size, padSize, unpadded), func(t *testing.T) {
+						opts := []Option{WithEncoding(encoding), WithKey(key), WithSalt(salt), WithRecordSize(55)}
+						content, err := Encrypt(plaintext[:size], append(opts, WithPadSize(padSize))...)
+						assert.Nil(t, err)
+
+						r, err := NewDecryptReaderAt(bytes.NewReader(content), int64(len(content)), append(opts, WithUnpaddedRecords(unpadded))...)
+						assert.Nil(t, err)
+
+						n, err := r.Size()
+						assert.Nil(t, err)
+						assert.Equal(t, int64(size), n)
+
+						for _, off := range []int{0, 1, size / 2, size - 1} {
+							for _, l := range []int{1, 10, 100} {
+								buf := make([]byte, l)
+								n, err := r.ReadAt(buf, int64(off))
+								expected := plaintext[off:min(off+l, size)]
+								assert.Equal(t, len(expected), n)
+								assert.Equal(t, expected, buf[:n])
+								if n < l {
+									assert.ErrorIs(t, err, io.EOF)
+								} else {
+									assert.Nil(t, err)
+								}
+							}
+						}
+
+						_, err = r.ReadAt(make([]byte, 1), int64(size))
+						assert.ErrorIs(t, err, io.EOF)
+
+						_, err = r.Seek(0, io.SeekStart)
+						assert.Nil(t, err)
+						assert.Nil(t, iotest.TestReader(r, plaintext[:size]))
+					})
+				}
+			}
+		}
+	}
+}
+
+func TestDecryptReaderAt_UnexpectedPadding(t *testing.T) {
+	key := []byte("0123456789abcdef")
+	plaintext := make([]byte, 200)
+	for i := range plaintext {
+		plaintext[i] = byte(i)
+	}
+	// The padding fills the first record, which holds 8 bytes instead of 38.
+	content, err := Encrypt(plaintext, WithKey(key), WithRecordSize(55), WithPadSize(30))
+	assert.Nil(t, err)
+
+	r, err := NewDecryptReaderAt(bytes.NewReader(content), int64(len(content)), WithKey(key), WithUnpaddedRecords(true))
+	assert.Nil(t, err)
+	_, err = r.ReadAt(make([]byte, 10), 0)
+	assert.ErrorIs(t, err, ErrUnexpectedPadding)
+
+	// Only the records that are read are checked: later offsets are shifted by the padding,
+	// and the size is off by as much.
+	buf := make([]byte, 10)
+	n, err := r.ReadAt(buf, 100)
+	assert.Nil(t, err)
+	assert.Equal(t, 10, n)
+	assert.Equal(t, plaintext[70:80], buf)
+	size, err := r.Size()
+	assert.Nil(t, err)
+	assert.Equal(t, int64(230), size)
+}
+
+func TestDecryptReaderAt_Truncated(t *testing.T) {
+	key := []byte("0123456789abcdef")
+	content, err := Encrypt(make([]byte, 200), WithKey(key), WithRecordSize(55))
+	assert.Nil(t, err)
+
+	_, err = NewDecryptReaderAt(bytes.NewReader(content), 10, WithKey(key))
+	assert.ErrorIs(t, err, ErrTruncated)
+
+	// Cut at a record boundary.
+	size := int64(len(content) - (len(content)-86)%55)
+	r, err := NewDecryptReaderAt(bytes.NewReader(content), size, WithKey(key))
+	assert.Nil(t, err)
+	_, err = r.Size()
+	assert.ErrorIs(t, err, ErrTruncated)
+}
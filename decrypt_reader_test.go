@@ -0,0 +1,163 @@
+/*
+ * Copyright (c) 2019 Zenichi Amano
+ *
+ * This file is part of http-ece, which is MIT licensed.
+ * See http://opensource.org/licenses/MIT
+ */
+
+package httpece
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+	"testing/iotest"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDecryptReader(t *testing.T) {
+	authSecret := d(t, "9HcXsQe3xLMG/w2HsYKrOA==")
+	salt := d(t, "mRGYnIzSJGeZnJ19lgQcfw==")
+	senderPrivateKey := d(t, "/oQYbac5yEOeOeg+5D0QxOaB1YtiyONxkqmxU3+tq58=")
+	senderPublicKey := d(t, "BGJXZ4zDA04RfSgTufdauZXcNYbe3oF/yEri5ETSuZLDx70gYi7w2ytak8U82H01P1HYnIvr2fEeX7NZpeHdnhM=")
+	receiverPrivateKey := d(t, "yfSYB+/vCEoWklHCG7F99cQ1vRwemFYn87jZc8PHBwU=")
+	receiverPublicKey := d(t, "BBixsHNhTqN5jYhpguokCWQhGKoZlroyEj6GYr6hy79z1IeTEQdupEsW6xooLvM170j9Ekss3amjhOmDNP5Pi0s=")
+
+	for _, encoding := range []ContentEncoding{AES128GCM, AESGCM} {
+		for _, size := range []int{0, 1, 37, 38, 39, 1000} {
+			for _, padSize := range []int{0, 50} {
+				if size == 0 && padSize > 0 {
+					continue
+				}
+				t.Run(fmt.Sprintf("%s/%d/%d", encoding, size, padSize), func(t *testing.T) {
+					plaintext := []byte(strings.Repeat("a", size))
+					content, err := Encrypt(plaintext,
+						WithEncoding(encoding),
+						WithSalt(salt),
+						WithAuthSecret(authSecret),
+						WithPrivate(senderPrivateKey),
+						WithDh(receiverPublicKey),
+						WithRecordSize(55),
+						WithPadSize(padSize),
+					)
+					assert.Nil(t, err)
+
+					r, err := NewDecryptReader(iotest.OneByteReader(bytes.NewReader(content)),
+						WithEncoding(encoding),
+						WithSalt(salt),
+						WithAuthSecret(authSecret),
+						WithPrivate(receiverPrivateKey),
+						WithDh(senderPublicKey),
+						WithRecordSize(55),
+					)
+					assert.Nil(t, err)
+					result, err := io.ReadAll(r)
+					assert.Nil(t, err)
+					assert.Equal(t, plaintext, result)
+				})
+			}
+		}
+	}
+}
+
+func TestDecryptReaderRFC8291Example(t *testing.T) {
+	authSecret := d(t, "BTBZMqHH6r4Tts7J_aSIgg")
+	privateKey := d(t, "q1dXpw3UpT5VOmu_cf_v6ih07Aems3njxI-JWgLcM94")
+	content := d(t, "DGv6ra1nlYgDCS1FRnbzlwAAEABBBP4z9KsN6nGRTbVYI_c7VJSPQTBtkgcy27mlmlMoZIIgDll6e3vCYLocInmYWAmS6TlzAC8wEqKK6PBru3jl7A_yl95bQpu6cVPTpK4Mqgkf1CXztLVBSt2Ks3oZwbuwXPXLWyouBWLVWGNWQexSgSxsj_Qulcy4a-fN")
+
+	r, err := NewDecryptReader(bytes.NewReader(content),
+		WithAuthSecret(authSecret),
+		WithPrivate(privateKey),
+	)
+	assert.Nil(t, err)
+	plaintext, err := io.ReadAll(r)
+	assert.Nil(t, err)
+	assert.Equal(t, "When I grow up, I want to be a watermelon", string(plaintext))
+}
+
+func TestDecryptReader_KeyMap(t *testing.T) {
+	key := []byte("0123456789abcdef")
+	content, err := Encrypt([]byte(strings.Repeat("a", 100)), WithKey(key), WithKeyID([]byte("a1")), WithRecordSize(30))
+	assert.Nil(t, err)
+
+	r, err := NewDecryptReader(bytes.NewReader(content), WithKeyMap(func(keyID []byte) []byte {
+		if string(keyID) == "a1" {
+			return key
+		}
+		return nil
+	}))
+	assert.Nil(t, err)
+	plaintext, err := io.ReadAll(r)
+	assert.Nil(t, err)
+	assert.Equal(t, strings.Repeat("a", 100), string(plaintext))
+}
+
+func TestDecryptReader_Truncated(t *testing.T) {
+	key := []byte("0123456789abcdef")
+	content, err := Encrypt([]byte(strings.Repeat("a", 100)), WithKey(key), WithKeyID([]byte("a1")), WithRecordSize(30))
+	assert.Nil(t, err)
+	headerLen := keyLen + recodeSizeLen + 1 + 2
+
+	f := func(n int) {
+		r, err := NewDecryptReader(bytes.NewReader(content[:n]), WithKey(key))
+		if err == nil {
+			_, err = io.ReadAll(r)
+		}
+		assert.ErrorIs(t, err, ErrTruncated, "length %d", n)
+	}
+
+	// header
+	f(0)
+	f(10)
+	f(headerLen - 1)
+	// record boundaries
+	f(headerLen)
+	f(headerLen + 30)
+	f(headerLen + 60)
+	// inside tag
+	f(headerLen + 30 + 10)
+}
+
+func TestDecryptReader_WrongKey(t *testing.T) {
+	content, err := Encrypt([]byte("test"), WithKey([]byte("0123456789abcdef")))
+	assert.Nil(t, err)
+
+	r, err := NewDecryptReader(bytes.NewReader(content), WithKey([]byte("fedcba9876543210")))
+	assert.Nil(t, err)
+	_, err = io.ReadAll(r)
+	assert.EqualError(t, err, "record 0: cipher: message authentication failed")
+}
+
+func TestDecryptReader_TrailingData(t *testing.T) {
+	key := []byte("0123456789abcdef")
+	// The last record has full size.
+	content, err := Encrypt([]byte(strings.Repeat("a", 13)), WithKey(key), WithKeyID([]byte("a1")), WithRecordSize(30))
+	assert.Nil(t, err)
+	assert.Equal(t, headerLenMin+2+30, len(content))
+
+	r, err := NewDecryptReader(bytes.NewReader(append(content, 0)), WithKey(key))
+	assert.Nil(t, err)
+	_, err = io.ReadAll(r)
+	assert.ErrorIs(t, err, ErrTrailingData)
+
+	// Empty reads are not the end of the content.
+	empty := emptyReader(3)
+	r, err = NewDecryptReader(io.MultiReader(bytes.NewReader(content), &empty, bytes.NewReader([]byte{0})), WithKey(key))
+	assert.Nil(t, err)
+	_, err = io.ReadAll(r)
+	assert.ErrorIs(t, err, ErrTrailingData)
+}
+
+// emptyReader returns no data and no error for a number of reads.
+type emptyReader int
+
+func (r *emptyReader) Read([]byte) (int, error) {
+	if *r == 0 {
+		return 0, io.EOF
+	}
+	*r--
+	return 0, nil
+}
@@ -8,6 +8,8 @@
 package httpece
 
 import (
+	"bytes"
+	"io"
 	"strings"
 	"testing"
 
@@ -64,7 +66,7 @@ func TestDecryptWithAESGCM_WrongKey(t *testing.T) {
 		WithDh(senderPublicKey),
 	)
 
-	assert.EqualError(t, err, "cipher: message authentication failed")
+	assert.EqualError(t, err, "record 0: cipher: message authentication failed")
 	assert.Nil(t, plaintext)
 }
 
@@ -134,7 +136,7 @@ func TestDecryptWithAES128GCM_WrongKey(t *testing.T) {
 		WithDh(senderPublicKey),
 	)
 
-	assert.EqualError(t, err, "cipher: message authentication failed")
+	assert.EqualError(t, err, "record 0: cipher: message authentication failed")
 	assert.Nil(t, plaintext)
 }
 
@@ -183,3 +185,115 @@ func TestDecryptRFC8291Example(t *testing.T) {
 	assert.Nil(t, err)
 	assert.Equalf(t, "When I grow up, I want to be a watermelon", string(plaintext), "")
 }
+
+func TestDecrypt_Truncated(t *testing.T) {
+	key := []byte("0123456789abcdef")
+	content, err := Encrypt([]byte(strings.Repeat("a", 100)), WithKey(key), WithKeyID([]byte("a1")), WithRecordSize(30))
+	assert.Nil(t, err)
+	headerLen := keyLen + recodeSizeLen + 1 + 2
+
+	plaintext, err := Decrypt(content[:headerLen], WithKey(key))
+	assert.ErrorIs(t, err, ErrTruncated)
+	assert.Nil(t, plaintext)
+
+	plaintext, err = Decrypt(content[:headerLen+60], WithKey(key))
+	assert.ErrorIs(t, err, ErrTruncated)
+	assert.Nil(t, plaintext)
+}
+
+func TestDecrypt_TrailingData(t *testing.T) {
+	key := []byte("0123456789abcdef")
+	// The last record has full size.
+	content, err := Encrypt([]byte(strings.Repeat("a", 13)), WithKey(key), WithKeyID([]byte("a1")), WithRecordSize(30))
+	assert.Nil(t, err)
+	content = append(content, 0)
+
+	_, err = Decrypt(content, WithKey(key))
+	assert.ErrorIs(t, err, ErrTrailingData)
+	_, err = DecryptAppend(nil, content, WithKey(key))
+	assert.ErrorIs(t, err, ErrTrailingData)
+
+	w := NewDecryptWriter(io.Discard, WithKey(key))
+	_, err = w.Write(content)
+	if err == nil {
+		err = w.Close()
+	}
+	assert.ErrorIs(t, err, ErrTrailingData)
+
+	r, err := NewDecryptReader(bytes.NewReader(content), WithKey(key))
+	assert.Nil(t, err)
+	_, err = io.ReadAll(r)
+	assert.ErrorIs(t, err, ErrTrailingData)
+}
+
+func TestDecryptAppend(t *testing.T) {
+	for _, encoding := range []ContentEncoding{AES128GCM, AESGCM} {
+		opts := []Option{
+			WithEncoding(encoding),
+			WithKey([]byte("0123456789abcdef")),
+			WithSalt(d(t, "mRGYnIzSJGeZnJ19lgQcfw==")),
+			WithRecordSize(100),
+		}
+		plaintext := strings.Repeat("a", 1000)
+		content, err := Encrypt([]byte(plaintext), append(opts, WithPadSize(10))...)
+		assert.Nil(t, err)
+
+		result, err := DecryptAppend([]byte("prefix"), content, opts...)
+		assert.Nil(t, err)
+		assert.Equal(t, "prefix"+plaintext, string(result))
+
+		result, err = DecryptAppend([]byte("prefix"), content[:len(content)-1], opts...)
+		assert.NotNil(t, err)
+		assert.Equal(t, "prefix", string(result))
+	}
+}
+
+func TestDecryptAppend_Allocs(t *testing.T) {
+	key := []byte("0123456789abcdef")
+	allocs := func(size int) float64 {
+		content, err := Encrypt(make([]byte, size), WithKey(key), WithKeyID([]byte("a")), WithRecordSize(100))
+		assert.Nil(t, err)
+		dst := make([]byte, 0, len(content))
+		return testing.AllocsPerRun(10, func() {
+			_, _ = DecryptAppend(dst, content, WithKey(key))
+		})
+	}
+
+	// The number of allocations does not depend on the number of records.
+	assert.Equal(t, allocs(10), allocs(100000))
+}
+
+func BenchmarkDecrypt(b *testing.B) {
+	key := []byte("0123456789abcdef")
+	content, err := Encrypt(make([]byte, 1<<20), WithKey(key))
+	assert.Nil(b, err)
+
+	b.ReportAllocs()
+	b.SetBytes(int64(len(content)))
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		_, err = Decrypt(content, WithKey(key))
+	}
+	b.StopTimer()
+
+	assert.Nil(b, err)
+}
+
+func BenchmarkDecryptAppend(b *testing.B) {
+	key := []byte("0123456789abcdef")
+	content, err := Encrypt(make([]byte, 1<<20), WithKey(key))
+	assert.Nil(b, err)
+	dst := make([]byte, 0, len(content))
+
+	b.ReportAllocs()
+	b.SetBytes(int64(len(content)))
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		_, err = DecryptAppend(dst[:0], content, WithKey(key))
+	}
+	b.StopTimer()
+
+	assert.Nil(b, err)
+}
@@ -0,0 +1,145 @@
+/*
+ * Copyright (c) 2019 Zenichi Amano
+ *
+ * This file is part of http-ece, which is MIT licensed.
+ * See http://opensource.org/licenses/MIT
+ */
+
+package httpece
+
+import (
+	"io"
+)
+
+type decryptWriter struct {
+	dst    io.Writer
+	opt    *options
+	state  *decryptState      // nil until the header has been parsed
+	buf    []byte             // buffered header or ciphertext
+	plain  []byte             // decrypted record
+	digest *ciphertextDigests // nil without digest options
+	err    error
+	closed bool
+}
+
+// NewDecryptWriter returns a writer that decrypts content written to it and writes the plaintext to dst.
+// Plaintext is written record by record once the record has been authenticated.
+// Errors in the options are reported by the first call to Write or Close.
+func NewDecryptWriter(dst io.Writer, opts ...Option) io.WriteCloser {
+	opt, err := parseOptions(decrypt, opts)
+	if err != nil {
+		return &decryptWriter{dst: dst, err: err}
+	}
+
+	return newDecryptWriter(dst, opt)
+}
+
+func newDecryptWriter(dst io.Writer, opt *options) io.WriteCloser {
+	w := &decryptWriter{dst: dst, opt: opt, digest: newCiphertextDigests(opt)}
+	if w.opt.encoding != AES128GCM || w.opt.header != nil {
+		// No header on other versions, or the header is kept apart.
+		if _, w.err = readHeader(w.opt, nil); w.err == nil {
+			w.err = w.start()
+		}
+	}
+	return w
+}
+
+func (w *decryptWriter) Write(p []byte) (int, error) {
+	if w.closed {
+		return 0, ErrClosed
+	}
+	if w.err != nil {
+		return 0, w.err
+	}
+
+	written := 0
+	for len(p) > 0 {
+		var n int
+		if w.state == nil {
+			n, w.err = w.writeHeader(p)
+		} else {
+			n, w.err = w.writeRecord(p)
+		}
+		_, _ = w.digest.Write(p[:n])
+		written += n
+		p = p[n:]
+		if w.err != nil {
+			return written, w.err
+		}
+	}
+	return written, nil
+}
+
+// Close decrypts the remaining data as the last record.
+// It fails with ErrTruncated if the last record was never seen.
+// It does not close the underlying writer.
+func (w *decryptWriter) Close() error {
+	if w.closed {
+		return nil
+	}
+	w.closed = true
+	if w.err != nil {
+		return w.err
+	}
+	if w.state == nil {
+		w.err = ErrTruncated
+	} else if w.err = w.flush(); w.err == nil {
+		w.err = w.digest.check()
+	}
+	return w.err
+}
+
+func (w *decryptWriter) writeHeader(p []byte) (int, error) {
+	n := min(headerLength(w.buf)-len(w.buf), len(p))
+	w.buf = append(w.buf, p[:n]...)
+	if len(w.buf) < headerLength(w.buf) {
+		return n, nil
+	}
+	if _, err := readHeader(w.opt, w.buf); err != nil {
+		return n, err
+	}
+	return n, w.start()
+}
+
+func (w *decryptWriter) start() error {
+	state, err := newDecryptState(w.opt)
+	if err != nil {
+		return err
+	}
+	w.state = state
+	// The header is referred to by the options.
+	w.buf = nil
+	return nil
+}
+
+func (w *decryptWriter) writeRecord(p []byte) (int, error) {
+	// One byte more than a record, to know whether another record follows.
+	size := w.state.blockSize + 1
+	n := min(len(p), size-len(w.buf))
+	w.buf = append(w.buf, p[:n]...)
+	if len(w.buf) < size {
+		return n, nil
+	}
+	return n, w.flush()
+}
+
+// flush decrypts the first record in the buffer and writes it.
+func (w *decryptWriter) flush() error {
+	opt := w.opt
+	total := len(w.buf)
+	end, err := opt.encoding.calculateCipherBlockEnd(w.state.gcm, 0, total, opt.recordSize)
+	if err != nil {
+		return err
+	}
+	last := end == total
+	if w.plain, err = w.state.openRecord(w.plain[:0], w.buf[:end], last); err != nil {
+		return err
+	}
+	w.buf = w.buf[:copy(w.buf, w.buf[end:])]
+	if len(w.plain) == 0 {
+		return nil
+	}
+	_, err = w.dst.Write(w.plain)
+	return err
+}
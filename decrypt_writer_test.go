@@ -0,0 +1,109 @@
+/*
+ * Copyright (c) 2019 Zenichi Amano
+ *
+ * This file is part of http-ece, which is MIT licensed.
+ * See http://opensource.org/licenses/MIT
+ */
+
+package httpece
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDecryptWriter(t *testing.T) {
+	authSecret := d(t, "9HcXsQe3xLMG/w2HsYKrOA==")
+	salt := d(t, "mRGYnIzSJGeZnJ19lgQcfw==")
+	senderPrivateKey := d(t, "/oQYbac5yEOeOeg+5D0QxOaB1YtiyONxkqmxU3+tq58=")
+	senderPublicKey := d(t, "BGJXZ4zDA04RfSgTufdauZXcNYbe3oF/yEri5ETSuZLDx70gYi7w2ytak8U82H01P1HYnIvr2fEeX7NZpeHdnhM=")
+	receiverPrivateKey := d(t, "yfSYB+/vCEoWklHCG7F99cQ1vRwemFYn87jZc8PHBwU=")
+	receiverPublicKey := d(t, "BBixsHNhTqN5jYhpguokCWQhGKoZlroyEj6GYr6hy79z1IeTEQdupEsW6xooLvM170j9Ekss3amjhOmDNP5Pi0s=")
+
+	for _, encoding := range []ContentEncoding{AES128GCM, AESGCM} {
+		for _, size := range []int{0, 1, 37, 38, 39, 1000} {
+			for _, chunk := range []int{1, 7, 55, 4096} {
+				t.Run(fmt.Sprintf("%s/%d/%d", encoding, size, chunk), func(t *testing.T) {
+					plaintext := []byte(strings.Repeat("a", size))
+					content, err := Encrypt(plaintext,
+						WithEncoding(encoding),
+						WithSalt(salt),
+						WithAuthSecret(authSecret),
+						WithPrivate(senderPrivateKey),
+						WithDh(receiverPublicKey),
+						WithRecordSize(55),
+					)
+					assert.Nil(t, err)
+
+					var buf bytes.Buffer
+					w := NewDecryptWriter(&buf,
+						WithEncoding(encoding),
+						WithSalt(salt),
+						WithAuthSecret(authSecret),
+						WithPrivate(receiverPrivateKey),
+						WithDh(senderPublicKey),
+						WithRecordSize(55),
+					)
+					for p := content; len(p) > 0; {
+						n := min(chunk, len(p))
+						written, err := w.Write(p[:n])
+						assert.Nil(t, err)
+						assert.Equal(t, n, written)
+						p = p[n:]
+					}
+					assert.Nil(t, w.Close())
+					assert.Equal(t, string(plaintext), buf.String())
+				})
+			}
+		}
+	}
+}
+
+func TestDecryptWriter_Truncated(t *testing.T) {
+	key := []byte("0123456789abcdef")
+	content, err := Encrypt([]byte(strings.Repeat("a", 100)), WithKey(key), WithKeyID([]byte("a1")), WithRecordSize(30))
+	assert.Nil(t, err)
+	headerLen := keyLen + recodeSizeLen + 1 + 2
+
+	f := func(n int) {
+		var buf bytes.Buffer
+		w := NewDecryptWriter(&buf, WithKey(key))
+		_, err := w.Write(content[:n])
+		assert.Nil(t, err)
+		assert.ErrorIs(t, w.Close(), ErrTruncated, "length %d", n)
+	}
+
+	f(0)
+	f(headerLen - 1)
+	f(headerLen)
+	f(headerLen + 30)
+	f(headerLen + 60)
+	f(headerLen + 30 + 10)
+}
+
+func TestDecryptWriter_InvalidOption(t *testing.T) {
+	var buf bytes.Buffer
+	w := NewDecryptWriter(&buf, WithRecordSize(-1))
+	_, err := w.Write([]byte("test"))
+	assert.EqualError(t, err, "invalid record size -1: must be between 0 and 2147483647")
+	assert.NotNil(t, w.Close())
+}
+
+func TestDecryptWriter_WriteAfterClose(t *testing.T) {
+	content, err := Encrypt([]byte("test"), WithKey([]byte("0123456789abcdef")))
+	assert.Nil(t, err)
+
+	var buf bytes.Buffer
+	w := NewDecryptWriter(&buf, WithKey([]byte("0123456789abcdef")))
+	_, err = w.Write(content)
+	assert.Nil(t, err)
+	assert.Nil(t, w.Close())
+	assert.Equal(t, "test", buf.String())
+
+	_, err = w.Write(content)
+	assert.ErrorIs(t, err, ErrClosed)
+}
@@ -0,0 +1,72 @@
+/*
+ * Copyright (c) 2019 Zenichi Amano
+ *
+ * This file is part of http-ece, which is MIT licensed.
+ * See http://opensource.org/licenses/MIT
+ */
+
+package httpece
+
+import (
+	"io"
+)
+
+// Decryptor decrypts messages with options that are validated once.
+// It is safe for concurrent use.
+type Decryptor struct {
+	opt   *options
+	index *lruCache[[]int64] // Plaintext record ends of content served by ServeContent
+}
+
+// NewDecryptor returns a Decryptor for the options.
+//
+// Derived keys are cached per key identifier and salt, and ECDH shared secrets per sender public key.
+// The size of each cache is bounded by WithCacheSize.
+// WithDigest is only accepted per message.
+func NewDecryptor(opts ...Option) (*Decryptor, error) {
+	var opt *options
+	var err error
+
+	// Options
+	if opt, err = parseOptions(decrypt, opts); err != nil {
+		return nil, err
+	}
+	if err = opt.checkShared(); err != nil {
+		return nil, err
+	}
+
+	opt.secretCache = newLRUCache[[]byte](opt.cacheSize)
+	opt.cipherCache = newLRUCache[cachedCipher](opt.cacheSize)
+
+	return &Decryptor{opt: opt, index: newLRUCache[[]int64](opt.cacheSize)}, nil
+}
+
+// Decrypt decrypts content data.
+// opts override the options of the Decryptor for this message.
+func (d *Decryptor) Decrypt(content []byte, opts ...Option) ([]byte, error) {
+	opt, err := d.opt.clone(opts)
+	if err != nil {
+		return nil, err
+	}
+	return decryptContent(nil, opt, content)
+}
+
+// NewReader returns a reader that decrypts content read from r, like NewDecryptReader.
+// opts override the options of the Decryptor for this message.
+func (d *Decryptor) NewReader(r io.Reader, opts ...Option) (io.Reader, error) {
+	opt, err := d.opt.clone(opts)
+	if err != nil {
+		return nil, err
+	}
+	return newDecryptReader(r, opt)
+}
+
+// NewWriter returns a writer that decrypts content written to it, like NewDecryptWriter.
+// opts override the options of the Decryptor for this message.
+func (d *Decryptor) NewWriter(dst io.Writer, opts ...Option) io.WriteCloser {
+	opt, err := d.opt.clone(opts)
+	if err != nil {
+		return &decryptWriter{dst: dst, err: err}
+	}
+	return newDecryptWriter(dst, opt)
+}
@@ -0,0 +1,110 @@
+/*
+ * Copyright (c) 2019 Zenichi Amano
+ *
+ * This file is part of http-ece, which is MIT licensed.
+ * See http://opensource.org/licenses/MIT
+ */
+
+package httpece
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDecryptor(t *testing.T) {
+	authSecret := d(t, "9HcXsQe3xLMG/w2HsYKrOA==")
+	peersPublicKey := d(t, "BBixsHNhTqN5jYhpguokCWQhGKoZlroyEj6GYr6hy79z1IeTEQdupEsW6xooLvM170j9Ekss3amjhOmDNP5Pi0s=")
+	receiverPrivateKey := d(t, "yfSYB+/vCEoWklHCG7F99cQ1vRwemFYn87jZc8PHBwU=")
+	plaintext := strings.Repeat("a", 5000)
+
+	dec, err := NewDecryptor(WithAuthSecret(authSecret), WithPrivate(receiverPrivateKey), WithCacheSize(2))
+	assert.Nil(t, err)
+
+	contents := make([][]byte, 3)
+	for i := range contents {
+		contents[i], err = Encrypt([]byte(plaintext), WithAuthSecret(authSecret), WithDh(peersPublicKey))
+		assert.Nil(t, err)
+	}
+
+	var wg sync.WaitGroup
+	for i := range 12 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			result, err := dec.Decrypt(contents[i%len(contents)])
+			assert.Nil(t, err)
+			assert.Equal(t, plaintext, string(result))
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, 2, dec.opt.secretCache.len())
+	assert.Equal(t, 2, dec.opt.cipherCache.len())
+
+	r, err := dec.NewReader(bytes.NewReader(contents[0]))
+	assert.Nil(t, err)
+	result, err := io.ReadAll(r)
+	assert.Nil(t, err)
+	assert.Equal(t, plaintext, string(result))
+
+	var buf bytes.Buffer
+	w := dec.NewWriter(&buf)
+	_, err = w.Write(contents[1])
+	assert.Nil(t, err)
+	assert.Nil(t, w.Close())
+	assert.Equal(t, plaintext, buf.String())
+}
+
+func TestDecryptor_KeyMap(t *testing.T) {
+	keys := map[string][]byte{
+		"a": []byte("0123456789abcdef"),
+		"b": []byte("fedcba9876543210"),
+	}
+	dec, err := NewDecryptor(WithKeyMap(func(keyID []byte) []byte {
+		return keys[string(keyID)]
+	}))
+	assert.Nil(t, err)
+
+	for keyID, key := range keys {
+		content, err := Encrypt([]byte(keyID), WithKey(key), WithKeyID([]byte(keyID)))
+		assert.Nil(t, err)
+		for range 2 {
+			plaintext, err := dec.Decrypt(content)
+			assert.Nil(t, err)
+			assert.Equal(t, keyID, string(plaintext))
+		}
+	}
+	assert.Equal(t, 2, dec.opt.cipherCache.len())
+}
+
+func TestDecryptor_AESGCM(t *testing.T) {
+	authSecret := d(t, "9HcXsQe3xLMG/w2HsYKrOA==")
+	salt := d(t, "mRGYnIzSJGeZnJ19lgQcfw==")
+	privateKey := d(t, "yfSYB+/vCEoWklHCG7F99cQ1vRwemFYn87jZc8PHBwU=")
+	senderPublicKey := d(t, "BGJXZ4zDA04RfSgTufdauZXcNYbe3oF/yEri5ETSuZLDx70gYi7w2ytak8U82H01P1HYnIvr2fEeX7NZpeHdnhM=")
+	content := d(t, "vOjpVgZE4IYn/uEJKk3DzZ4X+Qr1dgSSUkuIzQE=")
+
+	dec, err := NewDecryptor(WithEncoding(AESGCM), WithAuthSecret(authSecret), WithPrivate(privateKey))
+	assert.Nil(t, err)
+
+	plaintext, err := dec.Decrypt(content, WithSalt(salt), WithDh(senderPublicKey))
+	assert.Nil(t, err)
+	assert.Equal(t, "hello world", string(plaintext))
+}
+
+func TestDecryptor_NoCache(t *testing.T) {
+	dec, err := NewDecryptor(WithKey([]byte("0123456789abcdef")), WithCacheSize(0))
+	assert.Nil(t, err)
+	assert.Nil(t, dec.opt.cipherCache)
+
+	content, err := Encrypt([]byte("test"), WithKey([]byte("0123456789abcdef")))
+	assert.Nil(t, err)
+	plaintext, err := dec.Decrypt(content)
+	assert.Nil(t, err)
+	assert.Equal(t, "test", string(plaintext))
+}
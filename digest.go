@@ -0,0 +1,334 @@
+/*
+ * Copyright (c) 2019 Zenichi Amano
+ *
+ * This file is part of http-ece, which is MIT licensed.
+ * See http://opensource.org/licenses/MIT
+ */
+
+package httpece
+
+import (
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"slices"
+	"strings"
+)
+
+const (
+	headerContentDigest = "Content-Digest"
+	headerReprDigest    = "Repr-Digest"
+	headerTrailer       = "Trailer"
+)
+
+// DigestAlgorithm is a hash algorithm of the Content-Digest and Repr-Digest fields of RFC 9530.
+type DigestAlgorithm string
+
+const (
+	DigestSHA256 DigestAlgorithm = "sha-256"
+	DigestSHA512 DigestAlgorithm = "sha-512"
+)
+
+// newHash returns the hash of the algorithm.
+func (a DigestAlgorithm) newHash() (hash.Hash, error) {
+	switch a {
+	case DigestSHA256:
+		return sha256.New(), nil
+	case DigestSHA512:
+		return sha512.New(), nil
+	default:
+		return nil, fmt.Errorf("%w: %s", ErrUnsupportedDigest, a)
+	}
+}
+
+// Digest computes the digest of encrypted content, which can be checked without the key.
+// Over HTTP it is the value of Content-Digest, and of Repr-Digest when the content is the whole representation.
+type Digest struct {
+	alg DigestAlgorithm
+	h   hash.Hash
+}
+
+// NewDigest returns a digest with the algorithm.
+func NewDigest(alg DigestAlgorithm) (*Digest, error) {
+	h, err := alg.newHash()
+	if err != nil {
+		return nil, err
+	}
+	return &Digest{alg: alg, h: h}, nil
+}
+
+// Write adds content to the digest.
+func (d *Digest) Write(p []byte) (int, error) {
+	return d.h.Write(p)
+}
+
+// Algorithm returns the algorithm of the digest.
+func (d *Digest) Algorithm() DigestAlgorithm {
+	return d.alg
+}
+
+// Sum returns the digest of the content written so far.
+func (d *Digest) Sum() []byte {
+	return d.h.Sum(nil)
+}
+
+// String returns the digest as a field value, e.g. "sha-256=:...:".
+func (d *Digest) String() string {
+	return FormatDigest(map[DigestAlgorithm][]byte{d.alg: d.Sum()})
+}
+
+// WithDigest writes the ciphertext of the message into dst, header included:
+// the output when encrypting, and the input when decrypting. A digest covers a single message,
+// so Encryptor and Decryptor take it per message.
+func WithDigest(dst *Digest) Option {
+	return func(opts *options) error {
+		opts.digest = dst
+		return nil
+	}
+}
+
+// WithVerifyDigest checks the ciphertext of the decrypted message against the Content-Digest or Repr-Digest value.
+// Decrypt and DecryptAppend check before decrypting; readers and writers check at the end of the content,
+// where they fail with ErrDigestMismatch instead of completing. NewDecryptReaderAt does not check.
+func WithVerifyDigest(value string) Option {
+	return func(opts *options) error {
+		if _, err := ParseDigest(value); err != nil {
+			return err
+		}
+		opts.verifyDigest = value
+		return nil
+	}
+}
+
+// WithDigestAlgorithm makes the HTTP integration send the digest of encrypted bodies in a Content-Digest trailer:
+// EncryptResponseHandler for responses, and Transport for requests.
+func WithDigestAlgorithm(value DigestAlgorithm) Option {
+	return func(opts *options) error {
+		if _, err := value.newHash(); err != nil {
+			return err
+		}
+		opts.digestAlg = value
+		return nil
+	}
+}
+
+// FormatDigest formats digests as a Content-Digest or Repr-Digest field value.
+func FormatDigest(digests map[DigestAlgorithm][]byte) string {
+	values := make([]string, 0, len(digests))
+	// Strongest first.
+	for _, alg := range []DigestAlgorithm{DigestSHA512, DigestSHA256} {
+		if sum, ok := digests[alg]; ok {
+			values = append(values, string(alg)+"=:"+base64.StdEncoding.EncodeToString(sum)+":")
+		}
+	}
+	return strings.Join(values, ", ")
+}
+
+// ParseDigest parses a Content-Digest or Repr-Digest field value.
+// Algorithms are lowercased, and unknown ones are kept.
+func ParseDigest(value string) (map[DigestAlgorithm][]byte, error) {
+	digests := make(map[DigestAlgorithm][]byte)
+	for entry := range strings.SplitSeq(value, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		alg, v, ok := strings.Cut(entry, "=")
+		// Parameters are not used.
+		v, _, _ = strings.Cut(v, ";")
+		v = strings.TrimSpace(v)
+		if !ok || len(v) < 2 || v[0] != ':' || v[len(v)-1] != ':' {
+			return nil, fmt.Errorf("invalid digest: %q", value)
+		}
+		sum, err := base64.StdEncoding.DecodeString(v[1 : len(v)-1])
+		if err != nil {
+			return nil, fmt.Errorf("invalid digest: %q", value)
+		}
+		digests[DigestAlgorithm(strings.ToLower(strings.TrimSpace(alg)))] = sum
+	}
+	return digests, nil
+}
+
+// digestVerifier checks ciphertext against an expected digest that may only be known at the end, e.g. from a trailer.
+type digestVerifier struct {
+	expected func() string
+	hashes   map[DigestAlgorithm]hash.Hash
+	checked  bool
+	err      error
+}
+
+func newDigestVerifier(expected func() string) *digestVerifier {
+	return &digestVerifier{
+		expected: expected,
+		hashes: map[DigestAlgorithm]hash.Hash{
+			DigestSHA256: sha256.New(),
+			DigestSHA512: sha512.New(),
+		},
+	}
+}
+
+func (v *digestVerifier) Write(p []byte) (int, error) {
+	for _, h := range v.hashes {
+		_, _ = h.Write(p)
+	}
+	return len(p), nil
+}
+
+// check compares the ciphertext written so far with the expected digest.
+// All supported algorithms of the expected value have to match.
+func (v *digestVerifier) check() error {
+	if v.checked {
+		return v.err
+	}
+	v.checked = true
+
+	value := v.expected()
+	digests, err := ParseDigest(value)
+	if err != nil {
+		v.err = err
+		return err
+	}
+	matched := 0
+	for alg, sum := range digests {
+		h, ok := v.hashes[alg]
+		if !ok {
+			continue
+		}
+		if subtle.ConstantTimeCompare(h.Sum(nil), sum) != 1 {
+			v.err = ErrDigestMismatch
+			return v.err
+		}
+		matched++
+	}
+	if matched == 0 {
+		v.err = fmt.Errorf("%w: %q", ErrUnsupportedDigest, value)
+	}
+	return v.err
+}
+
+// ciphertextDigests feeds the ciphertext of a message to its digests.
+type ciphertextDigests struct {
+	digest   *Digest
+	verifier *digestVerifier
+}
+
+// newCiphertextDigests returns the digests of the options, or nil if there are none.
+func newCiphertextDigests(opt *options) *ciphertextDigests {
+	if opt.digest == nil && opt.verifyDigest == "" {
+		return nil
+	}
+	c := &ciphertextDigests{digest: opt.digest}
+	if value := opt.verifyDigest; value != "" {
+		c.verifier = newDigestVerifier(func() string { return value })
+	}
+	return c
+}
+
+func (c *ciphertextDigests) Write(p []byte) (int, error) {
+	if c == nil {
+		return len(p), nil
+	}
+	if c.digest != nil {
+		_, _ = c.digest.Write(p)
+	}
+	if c.verifier != nil {
+		_, _ = c.verifier.Write(p)
+	}
+	return len(p), nil
+}
+
+// check verifies the ciphertext written so far, if a digest is expected.
+func (c *ciphertextDigests) check() error {
+	if c == nil || c.verifier == nil {
+		return nil
+	}
+	return c.verifier.check()
+}
+
+// digestReader feeds the content read from r to the digests, and checks them at its end.
+type digestReader struct {
+	r io.Reader
+	c *ciphertextDigests
+}
+
+func (r *digestReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	_, _ = r.c.Write(p[:n])
+	if err == io.EOF {
+		if err := r.c.check(); err != nil {
+			return n, err
+		}
+	}
+	return n, err
+}
+
+// ciphertextReader returns r wrapped to feed the digests of opt.
+func ciphertextReader(r io.Reader, opt *options) io.Reader {
+	if c := newCiphertextDigests(opt); c != nil {
+		return &digestReader{r: r, c: c}
+	}
+	return r
+}
+
+// messageDigest returns a function that reports the digest of an HTTP message: the header if present,
+// or else the trailer declared by the message, which is read at the end of the body and then removed,
+// since it does not describe the decoded body.
+// Repr-Digest is used when Content-Digest is absent and the content is the whole representation.
+// It returns nil if the message carries no digest.
+func messageDigest(h, trailer http.Header, partial bool) func() string {
+	names := []string{headerContentDigest}
+	if !partial {
+		names = append(names, headerReprDigest)
+	}
+	for _, name := range names {
+		if value := h.Get(name); value != "" {
+			return func() string { return value }
+		}
+	}
+	for _, name := range names {
+		if _, ok := trailer[name]; ok {
+			return func() string {
+				value := trailer.Get(name)
+				delete(trailer, name)
+				return value
+			}
+		}
+	}
+	return nil
+}
+
+// verifyBody returns body wrapped to check the digest of the message, if any.
+func verifyBody(body io.ReadCloser, h, trailer http.Header, partial bool) io.ReadCloser {
+	expected := messageDigest(h, trailer, partial)
+	if body == nil || expected == nil {
+		return body
+	}
+	return readCloser{&digestReader{r: body, c: &ciphertextDigests{verifier: newDigestVerifier(expected)}}, body}
+}
+
+// delDigest removes the digest fields of a message whose content has been changed, trailers included.
+func delDigest(h, trailer http.Header) {
+	names := []string{headerContentDigest, headerReprDigest}
+	for _, name := range names {
+		h.Del(name)
+		delete(trailer, name)
+	}
+
+	var declared []string
+	for _, value := range h.Values(headerTrailer) {
+		for name := range strings.SplitSeq(value, ",") {
+			if name = strings.TrimSpace(name); name != "" && !slices.Contains(names, http.CanonicalHeaderKey(name)) {
+				declared = append(declared, name)
+			}
+		}
+	}
+	h.Del(headerTrailer)
+	if len(declared) > 0 {
+		h.Set(headerTrailer, strings.Join(declared, ", "))
+	}
+}
@@ -0,0 +1,254 @@
+/*
+ * Copyright (c) 2019 Zenichi Amano
+ *
+ * This file is part of http-ece, which is MIT licensed.
+ * See http://opensource.org/licenses/MIT
+ */
+
+package httpece
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/sha512"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func sha256Digest(b []byte) string {
+	sum := sha256.Sum256(b)
+	return FormatDigest(map[DigestAlgorithm][]byte{DigestSHA256: sum[:]})
+}
+
+func TestDigest(t *testing.T) {
+	d, err := NewDigest(DigestSHA256)
+	assert.Nil(t, err)
+	_, _ = d.Write([]byte("hello"))
+	assert.Equal(t, DigestSHA256, d.Algorithm())
+	// RFC 9530, appendix D.
+	assert.Equal(t, "sha-256=:LPJNul+wow4m6DsqxbninhsWHlwfp0JecwQzYpOLmCQ=:", d.String())
+
+	_, err = NewDigest("md5")
+	assert.True(t, errors.Is(err, ErrUnsupportedDigest))
+	_, err = parseOptions(encrypt, []Option{WithDigestAlgorithm("md5")})
+	assert.True(t, errors.Is(err, ErrUnsupportedDigest))
+}
+
+func TestParseDigest(t *testing.T) {
+	sum256 := sha256.Sum256([]byte("hello"))
+	sum512 := sha512.Sum512([]byte("hello"))
+	value := FormatDigest(map[DigestAlgorithm][]byte{DigestSHA256: sum256[:], DigestSHA512: sum512[:]})
+	assert.True(t, strings.HasPrefix(value, "sha-512=:"))
+
+	digests, err := ParseDigest(value + ", unixsum=:AAAA:;x=1")
+	assert.Nil(t, err)
+	assert.Equal(t, map[DigestAlgorithm][]byte{
+		DigestSHA256: sum256[:],
+		DigestSHA512: sum512[:],
+		"unixsum":    {0, 0, 0},
+	}, digests)
+
+	for _, value := range []string{"sha-256", "sha-256=abc", "sha-256=:abc", "sha-256=:!!!!:"} {
+		_, err := ParseDigest(value)
+		assert.NotNil(t, err, value)
+	}
+}
+
+func TestWithDigest(t *testing.T) {
+	key := []byte("0123456789abcdef")
+	plaintext := bytes.Repeat([]byte("a"), 10000)
+	opts := []Option{WithKey(key), WithKeyID([]byte("a")), WithSalt(make([]byte, 16)), WithRecordSize(1000)}
+
+	d, _ := NewDigest(DigestSHA256)
+	content, err := Encrypt(plaintext, append(opts, WithDigest(d))...)
+	assert.Nil(t, err)
+	assert.Equal(t, sha256Digest(content), d.String())
+
+	d, _ = NewDigest(DigestSHA256)
+	_, err = io.ReadAll(NewEncryptReader(bytes.NewReader(plaintext), append(opts, WithDigest(d))...))
+	assert.Nil(t, err)
+	assert.Equal(t, sha256Digest(content), d.String())
+
+	d, _ = NewDigest(DigestSHA256)
+	w, err := NewEncryptWriter(io.Discard, append(opts, WithDigest(d))...)
+	assert.Nil(t, err)
+	_, _ = w.Write(plaintext)
+	assert.Nil(t, w.Close())
+	assert.Equal(t, sha256Digest(content), d.String())
+
+	d, _ = NewDigest(DigestSHA256)
+	r, err := NewDecryptReader(bytes.NewReader(content), WithKey(key), WithDigest(d))
+	assert.Nil(t, err)
+	_, err = io.ReadAll(r)
+	assert.Nil(t, err)
+	assert.Equal(t, sha256Digest(content), d.String())
+}
+
+func TestWithDigest_Shared(t *testing.T) {
+	key := []byte("0123456789abcdef")
+	d, _ := NewDigest(DigestSHA256)
+	_, err := NewEncryptor(WithKey(key), WithDigest(d))
+	assert.NotNil(t, err)
+	_, err = NewDecryptor(WithKey(key), WithDigest(d))
+	assert.NotNil(t, err)
+
+	// Per message, the digest covers the content.
+	e, err := NewEncryptor(WithKey(key))
+	assert.Nil(t, err)
+	content, err := e.Encrypt([]byte("hello"), WithDigest(d))
+	assert.Nil(t, err)
+	_, err = Decrypt(content, WithKey(key), WithVerifyDigest(d.String()))
+	assert.Nil(t, err)
+}
+
+func TestWithVerifyDigest(t *testing.T) {
+	key := []byte("0123456789abcdef")
+	plaintext := bytes.Repeat([]byte("a"), 10000)
+	content, err := Encrypt(plaintext, WithKey(key), WithRecordSize(1000))
+	assert.Nil(t, err)
+
+	tests := []struct {
+		name  string
+		value string
+		err   error
+	}{
+		{"match", sha256Digest(content), nil},
+		{"mismatch", sha256Digest(plaintext), ErrDigestMismatch},
+		{"unsupported", "md5=:AAAA:", ErrUnsupportedDigest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := []Option{WithKey(key), WithVerifyDigest(tt.value)}
+
+			_, err := Decrypt(content, opts...)
+			assert.True(t, errors.Is(err, tt.err), err)
+
+			r, err := NewDecryptReader(bytes.NewReader(content), opts...)
+			assert.Nil(t, err)
+			_, err = io.ReadAll(r)
+			assert.True(t, errors.Is(err, tt.err), err)
+
+			w := NewDecryptWriter(io.Discard, opts...)
+			_, err = w.Write(content)
+			assert.Nil(t, err)
+			assert.True(t, errors.Is(w.Close(), tt.err))
+		})
+	}
+
+	_, err = Decrypt(content, WithKey(key), WithVerifyDigest("sha-256"))
+	assert.NotNil(t, err)
+}
+
+func TestDigest_HTTP(t *testing.T) {
+	requestKey := []byte("0123456789abcdef")
+	responseKey := []byte("fedcba9876543210")
+	plaintext := strings.Repeat("a", 10000)
+
+	var handler http.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "", r.Header.Get(headerContentDigest))
+		w.Header().Set(headerContentDigest, "sha-256=:AAAA:")
+		echoHandler(t).ServeHTTP(w, r)
+	})
+	handler = EncryptResponseHandler(handler, nil, WithKey(responseKey), WithDigestAlgorithm(DigestSHA256))
+	handler = DecryptRequestHandler(handler, WithKey(requestKey))
+	server := httptest.NewServer(handler)
+	defer server.Close()
+
+	t.Run("trailer", func(t *testing.T) {
+		req, err := http.NewRequest(http.MethodGet, server.URL, nil)
+		assert.Nil(t, err)
+		req.Header.Set(headerAcceptEncoding, string(AES128GCM))
+		res, err := server.Client().Do(req)
+		assert.Nil(t, err)
+		defer res.Body.Close()
+
+		assert.Equal(t, "", res.Header.Get(headerContentDigest))
+		_, ok := res.Trailer[headerContentDigest]
+		assert.True(t, ok)
+		content, err := io.ReadAll(res.Body)
+		assert.Nil(t, err)
+		assert.Equal(t, sha256Digest(content), res.Trailer.Get(headerContentDigest))
+	})
+
+	t.Run("header", func(t *testing.T) {
+		content, err := Encrypt([]byte(plaintext), WithKey(requestKey))
+		assert.Nil(t, err)
+		for _, value := range []string{sha256Digest(content), sha256Digest([]byte(plaintext))} {
+			req, err := http.NewRequest(http.MethodPost, server.URL, bytes.NewReader(content))
+			assert.Nil(t, err)
+			req.Header.Set(headerContentEncoding, string(AES128GCM))
+			req.Header.Set(headerContentDigest, value)
+			res, err := server.Client().Do(req)
+			assert.Nil(t, err)
+			_ = res.Body.Close()
+
+			if value == sha256Digest(content) {
+				assert.Equal(t, http.StatusOK, res.StatusCode)
+			} else {
+				assert.Equal(t, http.StatusBadRequest, res.StatusCode)
+			}
+		}
+	})
+
+	t.Run("transport", func(t *testing.T) {
+		client := &http.Client{Transport: &Transport{
+			Base: server.Client().Transport,
+			EncryptOptions: func(r *http.Request) ([]Option, error) {
+				return []Option{WithKey(requestKey)}, nil
+			},
+			DecryptOptions: func(r *http.Request) ([]Option, error) {
+				return []Option{WithKey(responseKey)}, nil
+			},
+			Options: []Option{WithDigestAlgorithm(DigestSHA512)},
+		}}
+
+		res, err := client.Post(server.URL, "text/plain", strings.NewReader(plaintext))
+		assert.Nil(t, err)
+		defer res.Body.Close()
+		body, err := io.ReadAll(res.Body)
+		assert.Nil(t, err)
+		assert.Equal(t, plaintext, string(body))
+		assert.Equal(t, "", res.Trailer.Get(headerContentDigest))
+	})
+}
+
+func TestTransport_DigestMismatch(t *testing.T) {
+	key := []byte("0123456789abcdef")
+	content, err := Encrypt([]byte("hello"), WithKey(key))
+	assert.Nil(t, err)
+
+	client := &http.Client{Transport: &Transport{
+		Base: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+			h := http.Header{}
+			h.Set(headerContentEncoding, string(AES128GCM))
+			h.Set(headerReprDigest, sha256Digest([]byte("hello")))
+			return &http.Response{StatusCode: http.StatusOK, Header: h, Body: io.NopCloser(bytes.NewReader(content)), Request: req}, nil
+		}),
+		Options: []Option{WithKey(key)},
+	}}
+
+	res, err := client.Get("http://example.com/")
+	assert.Nil(t, err)
+	defer res.Body.Close()
+	assert.Equal(t, "", res.Header.Get(headerReprDigest))
+	_, err = io.ReadAll(res.Body)
+	assert.True(t, errors.Is(err, ErrDigestMismatch))
+}
+
+func TestDelDigest(t *testing.T) {
+	h := http.Header{}
+	h.Set(headerContentDigest, "sha-256=:AAAA:")
+	h.Add(headerTrailer, "Content-Digest, X-Checksum")
+	h.Add(headerTrailer, "repr-digest")
+	trailer := http.Header{headerContentDigest: nil, headerReprDigest: nil, "X-Checksum": nil}
+
+	delDigest(h, trailer)
+	assert.Equal(t, http.Header{headerTrailer: {"X-Checksum"}}, h)
+	assert.Equal(t, http.Header{"X-Checksum": nil}, trailer)
+}
@@ -0,0 +1,108 @@
+/*
+ * Copyright (c) 2019 Zenichi Amano
+ *
+ * This file is part of http-ece, which is MIT licensed.
+ * See http://opensource.org/licenses/MIT
+ */
+
+package ecetest
+
+import (
+	"io"
+	"net/http"
+	"slices"
+	"testing"
+
+	httpece "github.com/crow-misia/http-ece"
+)
+
+// recordSizeDefault is the record size of aesgcm content without rs in its Encryption header.
+const recordSizeDefault = 4096
+
+// Records returns the number of records of aes128gcm content.
+func Records(content []byte) (int, error) {
+	_, records, err := split(content)
+	return len(records), err
+}
+
+// AssertValid checks that content is aes128gcm content with the number of records
+// that decrypts with the options, and returns the plaintext. A negative number of records is not checked.
+func AssertValid(t testing.TB, content []byte, records int, opts ...httpece.Option) []byte {
+	t.Helper()
+
+	n, err := Records(content)
+	if err != nil {
+		t.Errorf("invalid aes128gcm content: %v", err)
+		return nil
+	}
+	if records >= 0 && n != records {
+		t.Errorf("content has %d records, want %d", n, records)
+	}
+	return decrypt(t, content, append([]httpece.Option{httpece.WithEncoding(httpece.AES128GCM)}, opts...))
+}
+
+// AssertInvalid checks that content does not decrypt with the options, and returns the error.
+func AssertInvalid(t testing.TB, content []byte, opts ...httpece.Option) error {
+	t.Helper()
+
+	if _, err := httpece.Decrypt(content, opts...); err != nil {
+		return err
+	}
+	t.Errorf("content decrypts with the options")
+	return nil
+}
+
+// AssertResponse checks that the body of res is encrypted content with the number of records
+// that decrypts with the options, and returns the plaintext. The body is read and closed.
+//
+// Both aes128gcm and aesgcm are checked according to Content-Encoding;
+// for aesgcm the options of the Encryption and Crypto-Key headers come before opts.
+func AssertResponse(t testing.TB, res *http.Response, records int, opts ...httpece.Option) []byte {
+	t.Helper()
+
+	content, err := io.ReadAll(res.Body)
+	_ = res.Body.Close()
+	if err != nil {
+		t.Errorf("cannot read the body: %v", err)
+		return nil
+	}
+
+	switch encoding := res.Header.Get("Content-Encoding"); httpece.ContentEncoding(encoding) {
+	case httpece.AES128GCM:
+		return AssertValid(t, content, records, opts...)
+	case httpece.AESGCM:
+		crypto, err := httpece.ParseCryptoHeaders(res.Header)
+		if err != nil {
+			t.Errorf("invalid crypto headers: %v", err)
+			return nil
+		}
+		headerOpts, err := crypto.Options()
+		if err != nil {
+			t.Errorf("invalid crypto headers: %v", err)
+			return nil
+		}
+		rs := crypto.Encryption[0].RecordSize
+		if rs == 0 {
+			rs = recordSizeDefault
+		}
+		// Records of aesgcm are rs bytes of plaintext and a tag.
+		if n := (len(content) + int(rs) + 15) / (int(rs) + 16); records >= 0 && n != records {
+			t.Errorf("content has %d records, want %d", n, records)
+		}
+		return decrypt(t, content, append(slices.Clip(headerOpts), opts...))
+	default:
+		t.Errorf("unexpected Content-Encoding %q", encoding)
+		return nil
+	}
+}
+
+func decrypt(t testing.TB, content []byte, opts []httpece.Option) []byte {
+	t.Helper()
+
+	plaintext, err := httpece.Decrypt(content, opts...)
+	if err != nil {
+		t.Errorf("cannot decrypt content: %v", err)
+		return nil
+	}
+	return plaintext
+}
@@ -0,0 +1,75 @@
+/*
+ * Copyright (c) 2019 Zenichi Amano
+ *
+ * This file is part of http-ece, which is MIT licensed.
+ * See http://opensource.org/licenses/MIT
+ */
+
+package ecetest
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"testing"
+
+	httpece "github.com/crow-misia/http-ece"
+	"github.com/stretchr/testify/assert"
+)
+
+// recorder records the failures of assertions.
+type recorder struct {
+	testing.TB
+	errors []string
+}
+
+func (r *recorder) Helper() {}
+
+func (r *recorder) Errorf(format string, args ...any) {
+	r.errors = append(r.errors, fmt.Sprintf(format, args...))
+}
+
+func TestAssertValid(t *testing.T) {
+	content := encrypt(t)
+
+	r := &recorder{TB: t}
+	assert.NotNil(t, AssertValid(r, content, 3, httpece.WithKey(Key)))
+	assert.NotNil(t, AssertValid(r, content, -1, httpece.WithKey(Key)))
+	assert.Empty(t, r.errors)
+
+	AssertValid(r, content, 2, httpece.WithKey(Key))
+	assert.Equal(t, []string{"content has 3 records, want 2"}, r.errors)
+
+	r = &recorder{TB: t}
+	assert.Nil(t, AssertValid(r, content, 3, httpece.WithKey(NewKey("other"))))
+	assert.Len(t, r.errors, 1)
+
+	r = &recorder{TB: t}
+	assert.Nil(t, AssertInvalid(r, content, httpece.WithKey(Key)))
+	assert.Equal(t, []string{"content decrypts with the options"}, r.errors)
+}
+
+func TestAssertResponse(t *testing.T) {
+	newResponse := func(h http.Header, body []byte) *http.Response {
+		return &http.Response{Header: h, Body: io.NopCloser(bytes.NewReader(body))}
+	}
+
+	r := &recorder{TB: t}
+	h := http.Header{"Content-Encoding": {"aes128gcm"}}
+	AssertResponse(r, newResponse(h, encrypt(t)), 3, httpece.WithKey(Key))
+	assert.Empty(t, r.errors)
+
+	var crypto httpece.CryptoHeaders
+	content, err := httpece.Encrypt(bytes.Repeat([]byte{'a'}, 100), httpece.WithEncoding(httpece.AESGCM),
+		httpece.WithKey(Key), httpece.WithRecordSize(40), httpece.WithCryptoHeaders(&crypto))
+	assert.Nil(t, err)
+	h = http.Header{"Content-Encoding": {"aesgcm"}}
+	crypto.Set(h)
+	plaintext := AssertResponse(r, newResponse(h, content), 3, httpece.WithKey(Key))
+	assert.Empty(t, r.errors)
+	assert.Len(t, plaintext, 100)
+
+	AssertResponse(r, newResponse(http.Header{}, content), 3)
+	assert.Equal(t, []string{`unexpected Content-Encoding ""`}, r.errors)
+}
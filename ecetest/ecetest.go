@@ -0,0 +1,66 @@
+/*
+ * Copyright (c) 2019 Zenichi Amano
+ *
+ * This file is part of http-ece, which is MIT licensed.
+ * See http://opensource.org/licenses/MIT
+ */
+
+// Package ecetest provides utilities for testing code that exchanges encrypted content:
+// a test server, deterministic fixtures, assertions and fault injection.
+//
+// The fixtures are public, so never use them outside of tests.
+package ecetest
+
+import (
+	"crypto/ecdh"
+	"crypto/sha256"
+	"encoding/base64"
+	"strconv"
+)
+
+var (
+	// Key is the explicit key of the example in RFC 8188, section 3.1.
+	Key = mustDecode("yqdlZ-tYemfogSmv7Ws5PQ")
+	// Salt is the salt of the example in RFC 8188, section 3.1.
+	Salt = mustDecode("I1BsxtFttlv3u_Oo94xnmw")
+)
+
+// NewKey returns a 16-byte key derived from seed.
+func NewKey(seed string) []byte {
+	return derive("key", seed, 16)
+}
+
+// NewSalt returns a 16-byte salt derived from seed.
+func NewSalt(seed string) []byte {
+	return derive("salt", seed, 16)
+}
+
+// NewAuthSecret returns a 16-byte authentication secret derived from seed.
+func NewAuthSecret(seed string) []byte {
+	return derive("auth", seed, 16)
+}
+
+// NewKeyPair returns a P-256 key pair derived from seed,
+// as the private key for httpece.WithPrivate and the uncompressed public key for httpece.WithDh.
+func NewKeyPair(seed string) (private, public []byte) {
+	for i := 0; ; i++ {
+		// Few digests are out of range for a scalar, so this hardly loops.
+		key, err := ecdh.P256().NewPrivateKey(derive("p256/"+strconv.Itoa(i), seed, 32))
+		if err == nil {
+			return key.Bytes(), key.PublicKey().Bytes()
+		}
+	}
+}
+
+func derive(purpose, seed string, n int) []byte {
+	sum := sha256.Sum256([]byte("ecetest " + purpose + "\x00" + seed))
+	return sum[:n]
+}
+
+func mustDecode(s string) []byte {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		panic(err)
+	}
+	return b
+}
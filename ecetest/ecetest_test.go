@@ -0,0 +1,42 @@
+/*
+ * Copyright (c) 2019 Zenichi Amano
+ *
+ * This file is part of http-ece, which is MIT licensed.
+ * See http://opensource.org/licenses/MIT
+ */
+
+package ecetest
+
+import (
+	"testing"
+
+	httpece "github.com/crow-misia/http-ece"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFixtures(t *testing.T) {
+	// RFC 8188, section 3.1.
+	content := mustDecode("I1BsxtFttlv3u_Oo94xnmwAAEAAA-NAVub2qFgBEuQKRapoZu-IxkIva3MEB1PD-ly8Thjg")
+	assert.Equal(t, "I am the walrus", string(AssertValid(t, content, 1, httpece.WithKey(Key))))
+	assert.Equal(t, Salt, content[:16])
+
+	assert.Equal(t, NewKey("a"), NewKey("a"))
+	assert.NotEqual(t, NewKey("a"), NewKey("b"))
+	assert.NotEqual(t, NewKey("a"), NewSalt("a"))
+	assert.Len(t, NewAuthSecret("a"), 16)
+
+	private, public := NewKeyPair("receiver")
+	private2, public2 := NewKeyPair("receiver")
+	assert.Equal(t, private, private2)
+	assert.Equal(t, public, public2)
+	assert.Len(t, public, 65)
+
+	// The key pair works for Web Push.
+	sender, _ := NewKeyPair("sender")
+	auth := NewAuthSecret("receiver")
+	ciphertext, err := httpece.Encrypt([]byte("hello"),
+		httpece.WithPrivate(sender), httpece.WithDh(public), httpece.WithAuthSecret(auth), httpece.WithSalt(NewSalt("a")))
+	assert.Nil(t, err)
+	plaintext := AssertValid(t, ciphertext, 1, httpece.WithPrivate(private), httpece.WithAuthSecret(auth))
+	assert.Equal(t, "hello", string(plaintext))
+}
@@ -0,0 +1,176 @@
+/*
+ * Copyright (c) 2019 Zenichi Amano
+ *
+ * This file is part of http-ece, which is MIT licensed.
+ * See http://opensource.org/licenses/MIT
+ */
+
+package ecetest
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hkdf"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+
+	httpece "github.com/crow-misia/http-ece"
+)
+
+// Fault corrupts encrypted content. It returns a corrupted copy and leaves content unchanged.
+//
+// Faults that address records work on aes128gcm content, whose header locates the records.
+// Record indexes count from zero, negative indexes from the end: -1 is the last record.
+type Fault func(content []byte) ([]byte, error)
+
+// Truncate removes the last n bytes of the content, cutting the last record short.
+func Truncate(n int) Fault {
+	return func(content []byte) ([]byte, error) {
+		if n < 0 || n > len(content) {
+			return nil, fmt.Errorf("cannot truncate %d of %d bytes", n, len(content))
+		}
+		return bytes.Clone(content[:len(content)-n]), nil
+	}
+}
+
+// DropRecords removes the last n records, so that the content ends with a non-last record.
+func DropRecords(n int) Fault {
+	return func(content []byte) ([]byte, error) {
+		h, records, err := split(content)
+		if err != nil {
+			return nil, err
+		}
+		if n < 0 || n >= len(records) {
+			return nil, fmt.Errorf("cannot drop %d of %d records", n, len(records))
+		}
+		end := h.Len() + (len(records)-n)*int(h.RecordSize)
+		return bytes.Clone(content[:end]), nil
+	}
+}
+
+// FlipTagBit flips a bit in the authentication tag of the record, which fails its authentication.
+func FlipTagBit(record int) Fault {
+	return func(content []byte) ([]byte, error) {
+		h, records, err := split(content)
+		if err != nil {
+			return nil, err
+		}
+		i, err := index(record, len(records))
+		if err != nil {
+			return nil, err
+		}
+		result := bytes.Clone(content)
+		// The tag ends the record.
+		end := h.Len() + i*int(h.RecordSize) + len(records[i])
+		result[end-1] ^= 1
+		return result, nil
+	}
+}
+
+// WrongDelimiter re-encrypts the record with the delimiter of the other kind of record:
+// 0x02 on a non-last record, 0x01 on the last one. The record still authenticates,
+// so only the padding check rejects it: a last record followed by more records is reported as
+// httpece.ErrTrailingData, a non-last record at the end as httpece.ErrTruncated.
+// The content has to be encrypted with the explicit key.
+func WrongDelimiter(key []byte, record int) Fault {
+	return func(content []byte) ([]byte, error) {
+		h, records, err := split(content)
+		if err != nil {
+			return nil, err
+		}
+		i, err := index(record, len(records))
+		if err != nil {
+			return nil, err
+		}
+		gcm, baseNonce, err := newCipher(key, h.Salt)
+		if err != nil {
+			return nil, err
+		}
+
+		nonce := recordNonce(baseNonce, i)
+		plaintext, err := gcm.Open(nil, nonce, records[i], nil)
+		if err != nil {
+			return nil, fmt.Errorf("cannot open record %d: %w", i, err)
+		}
+		d := len(plaintext) - 1
+		for d >= 0 && plaintext[d] == 0 {
+			d--
+		}
+		if d < 0 || plaintext[d] != 1 && plaintext[d] != 2 {
+			return nil, fmt.Errorf("no delimiter in record %d", i)
+		}
+		plaintext[d] ^= 3 // 0x01 <-> 0x02
+
+		result := make([]byte, 0, len(content))
+		result = append(result, content[:h.Len()]...)
+		for j, r := range records {
+			if j == i {
+				r = gcm.Seal(nil, nonce, plaintext, nil)
+			}
+			result = append(result, r...)
+		}
+		return result, nil
+	}
+}
+
+// split returns the header of aes128gcm content and its records.
+func split(content []byte) (httpece.Header, [][]byte, error) {
+	h, rest, err := httpece.ParseHeader(content)
+	if err != nil {
+		return httpece.Header{}, nil, err
+	}
+	if len(rest) == 0 {
+		return httpece.Header{}, nil, errors.New("content without records")
+	}
+
+	var records [][]byte
+	for len(rest) > 0 {
+		n := min(int(h.RecordSize), len(rest))
+		records, rest = append(records, rest[:n]), rest[n:]
+	}
+	return h, records, nil
+}
+
+// index resolves the record index i of content with n records.
+func index(i, n int) (int, error) {
+	j := i
+	if j < 0 {
+		j += n
+	}
+	if j < 0 || j >= n {
+		return 0, fmt.Errorf("record %d out of range: content has %d records", i, n)
+	}
+	return j, nil
+}
+
+// newCipher derives the content encryption key and base nonce of RFC 8188 from an explicit key.
+func newCipher(key, salt []byte) (cipher.AEAD, []byte, error) {
+	prk, err := hkdf.Extract(sha256.New, key, salt)
+	if err != nil {
+		return nil, nil, err
+	}
+	cek, err := hkdf.Expand(sha256.New, prk, "Content-Encoding: aes128gcm\x00", 16)
+	if err != nil {
+		return nil, nil, err
+	}
+	baseNonce, err := hkdf.Expand(sha256.New, prk, "Content-Encoding: nonce\x00", 12)
+	if err != nil {
+		return nil, nil, err
+	}
+	block, err := aes.NewCipher(cek)
+	if err != nil {
+		return nil, nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	return gcm, baseNonce, err
+}
+
+// recordNonce returns the nonce of the record with sequence number seq.
+func recordNonce(baseNonce []byte, seq int) []byte {
+	nonce := bytes.Clone(baseNonce)
+	binary.BigEndian.PutUint64(nonce[4:], binary.BigEndian.Uint64(nonce[4:])^uint64(seq))
+	return nonce
+}
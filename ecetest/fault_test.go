@@ -0,0 +1,84 @@
+/*
+ * Copyright (c) 2019 Zenichi Amano
+ *
+ * This file is part of http-ece, which is MIT licensed.
+ * See http://opensource.org/licenses/MIT
+ */
+
+package ecetest
+
+import (
+	"errors"
+	"testing"
+
+	httpece "github.com/crow-misia/http-ece"
+	"github.com/stretchr/testify/assert"
+)
+
+func encrypt(t *testing.T) []byte {
+	t.Helper()
+	content, err := httpece.Encrypt([]byte("I am the walrus, goo goo g'joob"),
+		httpece.WithKey(Key), httpece.WithSalt(Salt), httpece.WithRecordSize(32))
+	assert.Nil(t, err)
+	return content
+}
+
+func TestFault(t *testing.T) {
+	content := encrypt(t)
+	original := append([]byte(nil), content...)
+	n, err := Records(content)
+	assert.Nil(t, err)
+	assert.Equal(t, 3, n)
+
+	tests := []struct {
+		name  string
+		fault Fault
+		err   error
+	}{
+		{"truncate", Truncate(1), nil},
+		{"drop records", DropRecords(1), nil},
+		{"flip tag bit", FlipTagBit(1), nil},
+		{"flip last tag bit", FlipTagBit(-1), nil},
+		{"delimiter of last record", WrongDelimiter(Key, -1), httpece.ErrTruncated},
+		{"delimiter of non-last record", WrongDelimiter(Key, 0), httpece.ErrTrailingData},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			corrupted, err := tt.fault(content)
+			assert.Nil(t, err)
+			assert.NotEqual(t, content, corrupted)
+			assert.Equal(t, original, content)
+
+			err = AssertInvalid(t, corrupted, httpece.WithKey(Key))
+			if tt.err != nil {
+				assert.True(t, errors.Is(err, tt.err), err)
+			}
+		})
+	}
+}
+
+func TestFault_OutOfRange(t *testing.T) {
+	content := encrypt(t)
+
+	for _, fault := range []Fault{Truncate(len(content) + 1), DropRecords(3), FlipTagBit(3), FlipTagBit(-4), WrongDelimiter(Key, 3)} {
+		_, err := fault(content)
+		assert.NotNil(t, err)
+	}
+	_, err := WrongDelimiter(NewKey("other"), 0)(content)
+	assert.NotNil(t, err)
+	_, err = FlipTagBit(0)(content[:10])
+	assert.NotNil(t, err)
+}
+
+func TestRecordNonce(t *testing.T) {
+	content := encrypt(t)
+	h, records, err := split(content)
+	assert.Nil(t, err)
+	gcm, baseNonce, err := newCipher(Key, h.Salt)
+	assert.Nil(t, err)
+
+	for i, record := range records {
+		_, err := gcm.Open(nil, recordNonce(baseNonce, i), record, nil)
+		assert.Nil(t, err, i)
+	}
+}
@@ -0,0 +1,229 @@
+/*
+ * Copyright (c) 2019 Zenichi Amano
+ *
+ * This file is part of http-ece, which is MIT licensed.
+ * See http://opensource.org/licenses/MIT
+ */
+
+package ecetest
+
+import (
+	"bytes"
+	"io"
+	"maps"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strconv"
+	"sync"
+
+	httpece "github.com/crow-misia/http-ece"
+)
+
+// Request is a request received by a Server, with the decrypted body.
+type Request struct {
+	Method   string
+	Path     string
+	Header   http.Header
+	Encoding httpece.ContentEncoding // encoding of the request body, empty when not encrypted
+	Body     []byte
+}
+
+// Server is an httptest.Server that accepts and returns encrypted bodies.
+//
+// Request bodies with Content-Encoding aes128gcm or aesgcm are decrypted before the handler sees them;
+// aesgcm takes the options of its Encryption and Crypto-Key headers first.
+// Responses are encrypted with the encoding of the request body, or the encoding of the server
+// for requests without an encrypted body; aesgcm responses carry the Encryption and Crypto-Key headers.
+// Bodies that fail to decrypt are answered with 400.
+type Server struct {
+	*httptest.Server
+
+	opt      serverOptions
+	mu       sync.Mutex
+	requests []Request
+}
+
+type serverOptions struct {
+	encoding   httpece.ContentEncoding
+	decOpts    []httpece.Option
+	encOpts    []httpece.Option
+	handler    http.Handler
+	fault      Fault
+	tlsEnabled bool
+}
+
+// Option configures a Server.
+type Option func(*serverOptions)
+
+// WithEncoding sets the encoding of responses to requests without an encrypted body. The default is aes128gcm.
+func WithEncoding(value httpece.ContentEncoding) Option {
+	return func(opts *serverOptions) {
+		opts.encoding = value
+	}
+}
+
+// WithKey decrypts and encrypts bodies with the explicit key.
+func WithKey(value []byte) Option {
+	return func(opts *serverOptions) {
+		opts.decOpts = append(opts.decOpts, httpece.WithKey(value))
+		opts.encOpts = append(opts.encOpts, httpece.WithKey(value))
+	}
+}
+
+// WithDecryptOptions adds options to decrypt request bodies.
+func WithDecryptOptions(value ...httpece.Option) Option {
+	return func(opts *serverOptions) {
+		opts.decOpts = append(opts.decOpts, value...)
+	}
+}
+
+// WithEncryptOptions adds options to encrypt response bodies.
+func WithEncryptOptions(value ...httpece.Option) Option {
+	return func(opts *serverOptions) {
+		opts.encOpts = append(opts.encOpts, value...)
+	}
+}
+
+// WithHandler sets the handler that writes the plaintext responses. The default echoes the request body.
+func WithHandler(value http.Handler) Option {
+	return func(opts *serverOptions) {
+		opts.handler = value
+	}
+}
+
+// WithFault corrupts the encrypted response bodies; a failing fault is answered with 500.
+func WithFault(value Fault) Option {
+	return func(opts *serverOptions) {
+		opts.fault = value
+	}
+}
+
+// WithTLS starts the server with TLS, see httptest.NewTLSServer.
+func WithTLS(value bool) Option {
+	return func(opts *serverOptions) {
+		opts.tlsEnabled = value
+	}
+}
+
+// NewServer starts and returns a new Server. The caller should call Close when finished.
+func NewServer(opts ...Option) *Server {
+	s := &Server{
+		opt: serverOptions{
+			encoding: httpece.AES128GCM,
+			handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				_, _ = io.Copy(w, r.Body)
+			}),
+		},
+	}
+	for _, opt := range opts {
+		opt(&s.opt)
+	}
+
+	if s.opt.tlsEnabled {
+		s.Server = httptest.NewTLSServer(http.HandlerFunc(s.serveHTTP))
+	} else {
+		s.Server = httptest.NewServer(http.HandlerFunc(s.serveHTTP))
+	}
+	return s
+}
+
+// Requests returns the requests received so far.
+func (s *Server) Requests() []Request {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return slices.Clone(s.requests)
+}
+
+func (s *Server) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	encoding := httpece.ContentEncoding(r.Header.Get("Content-Encoding"))
+	switch encoding {
+	case "":
+	case httpece.AES128GCM, httpece.AESGCM:
+		if body, err = s.decrypt(r.Header, encoding, body); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	default:
+		http.Error(w, "unsupported content coding "+string(encoding), http.StatusUnsupportedMediaType)
+		return
+	}
+
+	s.mu.Lock()
+	s.requests = append(s.requests, Request{
+		Method:   r.Method,
+		Path:     r.URL.Path,
+		Header:   r.Header.Clone(),
+		Encoding: encoding,
+		Body:     body,
+	})
+	s.mu.Unlock()
+
+	req := r.Clone(r.Context())
+	req.Body = io.NopCloser(bytes.NewReader(body))
+	req.ContentLength = int64(len(body))
+	req.Header.Del("Content-Encoding")
+	req.Header.Del("Content-Length")
+	rec := httptest.NewRecorder()
+	s.opt.handler.ServeHTTP(rec, req)
+
+	maps.Copy(w.Header(), rec.Header())
+	if r.Method == http.MethodHead || rec.Code < http.StatusOK ||
+		rec.Code == http.StatusNoContent || rec.Code == http.StatusNotModified {
+		w.WriteHeader(rec.Code)
+		return
+	}
+
+	if encoding == "" {
+		encoding = s.opt.encoding
+	}
+	content, err := s.encrypt(w.Header(), encoding, rec.Body.Bytes())
+	if err == nil && s.opt.fault != nil {
+		content, err = s.opt.fault(content)
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Encoding", string(encoding))
+	w.Header().Set("Content-Length", strconv.Itoa(len(content)))
+	w.WriteHeader(rec.Code)
+	_, _ = w.Write(content)
+}
+
+// decrypt decrypts a request body of the encoding.
+func (s *Server) decrypt(h http.Header, encoding httpece.ContentEncoding, body []byte) ([]byte, error) {
+	opts := []httpece.Option{httpece.WithEncoding(encoding)}
+	if encoding == httpece.AESGCM {
+		crypto, err := httpece.ParseCryptoHeaders(h)
+		if err != nil {
+			return nil, err
+		}
+		headerOpts, err := crypto.Options()
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, headerOpts...)
+	}
+	return httpece.Decrypt(body, append(opts, s.opt.decOpts...)...)
+}
+
+// encrypt encrypts a response body with the encoding, and sets the crypto headers of aesgcm in h.
+func (s *Server) encrypt(h http.Header, encoding httpece.ContentEncoding, body []byte) ([]byte, error) {
+	var crypto httpece.CryptoHeaders
+	opts := append([]httpece.Option{httpece.WithEncoding(encoding), httpece.WithCryptoHeaders(&crypto)}, s.opt.encOpts...)
+	content, err := httpece.Encrypt(body, opts...)
+	if err != nil {
+		return nil, err
+	}
+	if encoding == httpece.AESGCM {
+		crypto.Set(h)
+	}
+	return content, nil
+}
@@ -0,0 +1,125 @@
+/*
+ * Copyright (c) 2019 Zenichi Amano
+ *
+ * This file is part of http-ece, which is MIT licensed.
+ * See http://opensource.org/licenses/MIT
+ */
+
+package ecetest
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	httpece "github.com/crow-misia/http-ece"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestServer(t *testing.T) {
+	s := NewServer(WithKey(Key))
+	defer s.Close()
+
+	t.Run("aes128gcm", func(t *testing.T) {
+		content, err := httpece.Encrypt([]byte("hello"), httpece.WithKey(Key))
+		assert.Nil(t, err)
+		req, err := http.NewRequest(http.MethodPost, s.URL+"/echo", bytes.NewReader(content))
+		assert.Nil(t, err)
+		req.Header.Set("Content-Encoding", "aes128gcm")
+		res, err := s.Client().Do(req)
+		assert.Nil(t, err)
+
+		assert.Equal(t, http.StatusOK, res.StatusCode)
+		assert.Equal(t, "hello", string(AssertResponse(t, res, 1, httpece.WithKey(Key))))
+	})
+
+	t.Run("aesgcm", func(t *testing.T) {
+		var crypto httpece.CryptoHeaders
+		content, err := httpece.Encrypt([]byte("hello"), httpece.WithEncoding(httpece.AESGCM),
+			httpece.WithKey(Key), httpece.WithCryptoHeaders(&crypto))
+		assert.Nil(t, err)
+		req, err := http.NewRequest(http.MethodPost, s.URL, bytes.NewReader(content))
+		assert.Nil(t, err)
+		req.Header.Set("Content-Encoding", "aesgcm")
+		crypto.Set(req.Header)
+		res, err := s.Client().Do(req)
+		assert.Nil(t, err)
+
+		assert.Equal(t, "aesgcm", res.Header.Get("Content-Encoding"))
+		assert.Equal(t, "hello", string(AssertResponse(t, res, 1, httpece.WithKey(Key))))
+	})
+
+	t.Run("plaintext", func(t *testing.T) {
+		res, err := s.Client().Post(s.URL, "text/plain", strings.NewReader("hello"))
+		assert.Nil(t, err)
+		assert.Equal(t, "hello", string(AssertResponse(t, res, 1, httpece.WithKey(Key))))
+	})
+
+	t.Run("wrong key", func(t *testing.T) {
+		content, err := httpece.Encrypt([]byte("hello"), httpece.WithKey(NewKey("other")))
+		assert.Nil(t, err)
+		req, err := http.NewRequest(http.MethodPost, s.URL, bytes.NewReader(content))
+		assert.Nil(t, err)
+		req.Header.Set("Content-Encoding", "aes128gcm")
+		res, err := s.Client().Do(req)
+		assert.Nil(t, err)
+		_ = res.Body.Close()
+		assert.Equal(t, http.StatusBadRequest, res.StatusCode)
+	})
+
+	requests := s.Requests()
+	assert.Len(t, requests, 3)
+	assert.Equal(t, "/echo", requests[0].Path)
+	assert.Equal(t, httpece.AES128GCM, requests[0].Encoding)
+	assert.Equal(t, httpece.AESGCM, requests[1].Encoding)
+	assert.Equal(t, httpece.ContentEncoding(""), requests[2].Encoding)
+	for _, r := range requests {
+		assert.Equal(t, "hello", string(r.Body))
+	}
+}
+
+func TestServer_Options(t *testing.T) {
+	receiver, receiverPublic := NewKeyPair("receiver")
+	auth := NewAuthSecret("receiver")
+	s := NewServer(
+		WithTLS(true),
+		WithEncoding(httpece.AESGCM),
+		WithDecryptOptions(httpece.WithKey(Key)),
+		WithEncryptOptions(httpece.WithDh(receiverPublic), httpece.WithAuthSecret(auth)),
+		WithHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("X-Test", "1")
+			w.WriteHeader(http.StatusCreated)
+			_, _ = io.WriteString(w, strings.Repeat("a", 5000))
+		})),
+	)
+	defer s.Close()
+
+	res, err := s.Client().Get(s.URL)
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusCreated, res.StatusCode)
+	assert.Equal(t, "1", res.Header.Get("X-Test"))
+	plaintext := AssertResponse(t, res, 2, httpece.WithPrivate(receiver), httpece.WithAuthSecret(auth))
+	assert.Len(t, plaintext, 5000)
+}
+
+func TestServer_Fault(t *testing.T) {
+	s := NewServer(WithKey(Key), WithEncryptOptions(httpece.WithRecordSize(32)), WithFault(FlipTagBit(0)))
+	defer s.Close()
+
+	res, err := s.Client().Post(s.URL, "text/plain", strings.NewReader("I am the walrus, goo goo g'joob"))
+	assert.Nil(t, err)
+	content, err := io.ReadAll(res.Body)
+	assert.Nil(t, err)
+	_ = res.Body.Close()
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	AssertInvalid(t, content, httpece.WithKey(Key))
+
+	failing := NewServer(WithKey(Key), WithFault(FlipTagBit(5)))
+	defer failing.Close()
+	res, err = failing.Client().Post(failing.URL, "text/plain", strings.NewReader("hello"))
+	assert.Nil(t, err)
+	_ = res.Body.Close()
+	assert.Equal(t, http.StatusInternalServerError, res.StatusCode)
+}
@@ -10,7 +10,7 @@ package httpece
 import (
 	"crypto/cipher"
 	"fmt"
-	"math"
+	"slices"
 )
 
 // Encrypt encrypts plaintext data.
@@ -23,11 +23,69 @@ func Encrypt(plaintext []byte, opts ...Option) ([]byte, error) {
 		return nil, err
 	}
 
-	// Save the DH public key in the header unless keyID is set.
-	if opt.encoding == AES128GCM && len(opt.keyID) == 0 {
-		opt.keyID = opt.publicKey.Bytes()
+	return encryptContent(nil, opt, plaintext)
+}
+
+// EncryptAppend encrypts plaintext data and appends the result to dst.
+// The output is sized once and records are sealed in place.
+// dst and plaintext must not overlap.
+func EncryptAppend(dst, plaintext []byte, opts ...Option) ([]byte, error) {
+	var opt *options
+	var err error
+
+	// Options
+	if opt, err = parseOptions(encrypt, opts); err != nil {
+		return dst, err
+	}
+
+	return encryptContent(dst, opt, plaintext)
+}
+
+func encryptContent(dst []byte, opt *options, plaintext []byte) ([]byte, error) {
+	state, err := newEncryptState(opt)
+	if err != nil {
+		return dst, err
+	}
+
+	results := slices.Grow(dst, headerSize(opt)+state.sealedLen(len(plaintext)))
+	// Create header.
+	results, err = writeHeader(opt, results)
+	if err != nil {
+		return dst, err
 	}
 
+	// Encrypt records.
+	if opt.workers > 1 {
+		results, err = state.sealParallel(results, plaintext, opt.workers)
+	} else {
+		results, _, err = state.sealRecords(results, plaintext, true)
+	}
+	if err != nil {
+		return dst, err
+	}
+	if opt.digest != nil {
+		_, _ = opt.digest.Write(results[len(dst):])
+	}
+	return results, nil
+}
+
+// encryptState holds the per-message state shared by all records.
+type encryptState struct {
+	opt            *options
+	gcm            cipher.AEAD
+	baseNonce      nonce
+	baseRecordSize int
+	padSize        int
+	counter        uint32
+	done           bool
+	nonce          [nonceLen]byte
+}
+
+func newEncryptState(opt *options) (*encryptState, error) {
+	var err error
+
+	prepareHeader(opt)
+
 	debug.dumpBinary("recv pub key", opt.dh)
 	debug.dumpBinary("send prv key", opt.privateKey.Bytes())
 
@@ -42,89 +100,135 @@ func Encrypt(plaintext []byte, opts ...Option) ([]byte, error) {
 	}
 
 	// Derive key and nonce.
-	key, baseNonce, err := deriveKeyAndNonce(opt)
-	if err != nil {
-		return nil, err
-	}
-
-	gcm, err := createCipher(key)
+	gcm, baseNonce, err := createCipherAndNonce(opt)
 	if err != nil {
 		return nil, err
 	}
 
 	// Check Record Size
-	overhead := opt.encoding.overhead(gcm)
+	overhead := opt.encoding.overhead()
 	recordSize := int(opt.recordSize)
 	if recordSize <= overhead {
 		return nil, fmt.Errorf("recordSize has to be greater than %d", overhead)
 	}
+	storeCryptoHeaders(opt)
 
+	return &encryptState{
+		opt:            opt,
+		gcm:            gcm,
+		baseNonce:      baseNonce,
+		baseRecordSize: recordSize - overhead,
+		padSize:        opt.padSize,
+	}, nil
+}
+
+// sealRecords encrypts plaintext into records and appends them to dst.
+// Unless final is set, a record that might turn out to be the last one is held back;
+// the number of plaintext bytes consumed is returned.
+func (s *encryptState) sealRecords(dst, plaintext []byte, final bool) ([]byte, int, error) {
 	var (
-		baseRecordSize = recordSize - overhead
-		start          = 0
-		counter        = uint32(0)
-		plaintextLen   = len(plaintext)
-		padSize        = opt.padSize
-		recordNum      = 1 + (plaintextLen+padSize+baseRecordSize-1)/baseRecordSize
+		start        = 0
+		plaintextLen = len(plaintext)
+		err          error
 	)
 
-	results := make([][]byte, 0, recordNum)
-	// Create header.
-	results, err = writeHeader(opt, results)
-	if err != nil {
-		return nil, err
+	for !s.done {
+		end, recordPad, last := s.layout(start, plaintextLen)
+		if !final && end >= plaintextLen {
+			// Wait for more data to decide whether this is the last record.
+			break
+		}
+		if dst, err = s.sealRecord(dst, plaintext[start:min(end, plaintextLen)], recordPad, last); err != nil {
+			return dst, start, err
+		}
+		start = min(end, plaintextLen)
 	}
+	return dst, start, nil
+}
 
-	// Encrypt records.
-	last := false
-	for !last {
-		recordPad := opt.encoding.calculateRecordPadSize(padSize, baseRecordSize)
-		padSize -= recordPad
-		end := start + baseRecordSize - recordPad
-		last = opt.encoding.isLastBlock(padSize, plaintextLen, end)
+// layout returns the plaintext end and the padding of the next record starting at start,
+// and whether it is the last record when the plaintext ends at plaintextLen.
+// end may exceed plaintextLen.
+func (s *encryptState) layout(start, plaintextLen int) (end, recordPad int, last bool) {
+	encoding := s.opt.encoding
+	recordPad = encoding.calculateRecordPadSize(s.padSize, s.baseRecordSize)
+	end = start + s.baseRecordSize - recordPad
+	last = encoding.isLastBlock(s.padSize-recordPad, plaintextLen, end)
+	return end, recordPad, last
+}
+
+// sealRecord appends the next record holding plaintext to dst.
+// The padded record is laid out at the end of dst and sealed in place.
+func (s *encryptState) sealRecord(dst, plaintext []byte, recordPad int, last bool) ([]byte, error) {
+	encoding := s.opt.encoding
+
+	// Generate nonce.
+	putNonce(s.nonce[:], s.baseNonce, s.counter)
+	debug.dumpBinary("nonce", s.nonce[:])
+
+	offset := len(dst)
+	dst = slices.Grow(dst, s.recordLen(len(plaintext), recordPad))
+	var err error
+	if dst, err = encoding.appendPadding(dst, plaintext, recordPad, last); err != nil {
+		return dst[:offset], err
+	}
+	dst = s.gcm.Seal(dst[:offset], s.nonce[:], dst[offset:], nil)
+	debug.dumpBinary("result", dst[offset:])
+
+	s.padSize -= recordPad
+	s.counter++
+	s.done = last
+	return dst, nil
+}
+
+// recordLen returns the size of a record holding plaintextLen bytes and recordPad bytes of padding.
+func (s *encryptState) recordLen(plaintextLen, recordPad int) int {
+	return plaintextLen + s.opt.encoding.Padding() + recordPad + tagLen
+}
+
+// sealedLen returns the size of the records for plaintextLen bytes of plaintext.
+func (s *encryptState) sealedLen(plaintextLen int) int {
+	var (
+		t     = *s
+		start = 0
+		n     = 0
+	)
+
+	for {
+		end, recordPad, last := t.layout(start, plaintextLen)
 		end = min(end, plaintextLen)
-		// Generate nonce.
-		nonce := generateNonce(baseNonce, counter)
-		debug.dumpBinary("nonce", nonce)
-		r, err := encryptRecord(opt, gcm, nonce, plaintext[start:end], recordPad, last)
-		if err != nil {
-			return nil, err
-		}
-		results = append(results, r)
-		debug.dumpBinary("result", r)
+		n += t.recordLen(end-start, recordPad)
+		t.padSize -= recordPad
 		start = end
-		counter++
+		if last {
+			return n
+		}
 	}
-	return join(results), nil
 }
 
-func encryptRecord(opt *options, gcm cipher.AEAD, nonce, plaintext []byte, recordPad int, last bool) ([]byte, error) {
-	plaintextWithPadding, err := opt.encoding.appendPadding(plaintext, recordPad, last)
-	if err != nil {
-		return nil, err
+// prepareHeader sets the header fields of opt for encryption.
+func prepareHeader(opt *options) {
+	if opt.header != nil {
+		// Use the prebuilt header as is.
+		opt.header.apply(opt)
+	} else if opt.encoding == AES128GCM && len(opt.keyID) == 0 {
+		// Save the DH public key in the header unless keyID is set.
+		opt.keyID = opt.publicKey.Bytes()
 	}
-	return gcm.Seal(nil, nonce, plaintextWithPadding, nil), nil
 }
 
-func writeHeader(opt *options, results [][]byte) ([][]byte, error) {
-	switch opt.encoding {
-	case AES128GCM:
-		keyIDLen := len(opt.keyID)
-		if keyIDLen > math.MaxUint8 {
-			return nil, fmt.Errorf("invalid keyID length %d", keyIDLen)
-		}
-		saltLen := len(opt.salt)
-		if saltLen > math.MaxUint8 {
-			return nil, fmt.Errorf("invalid salt length %d", saltLen)
-		}
-		buffer := make([]byte, saltLen+4+1+keyIDLen)
-		copy(buffer, opt.salt)
-		copy(buffer[saltLen:], uint32ToBytes(opt.recordSize))
-		buffer[saltLen+4] = uint8(keyIDLen)
-		copy(buffer[saltLen+5:], opt.keyID)
-		return append(results, buffer), nil
-	default:
-		// No header on other versions
-		return results, nil
+// headerSize returns the size of the header.
+func headerSize(opt *options) int {
+	if opt.encoding != AES128GCM || opt.header != nil {
+		return 0
+	}
+	return opt.headerValue().Len()
+}
+
+func writeHeader(opt *options, dst []byte) ([]byte, error) {
+	if opt.encoding != AES128GCM || opt.header != nil {
+		// No header on other versions, or the header is kept apart.
+		return dst, nil
 	}
+	return opt.headerValue().AppendBinary(dst)
 }
@@ -0,0 +1,111 @@
+/*
+ * Copyright (c) 2019 Zenichi Amano
+ *
+ * This file is part of http-ece, which is MIT licensed.
+ * See http://opensource.org/licenses/MIT
+ */
+
+package httpece
+
+import (
+	"slices"
+	"sync"
+	"sync/atomic"
+)
+
+// parallelChunkSize is the amount of ciphertext a worker handles at a time.
+const parallelChunkSize = 256 * 1024
+
+// encryptJob is a run of records sealed by a worker.
+type encryptJob struct {
+	state  encryptState // state before the first record
+	start  int          // plaintext offset of the first record
+	offset int          // output offset of the first record
+	count  int          // number of records
+}
+
+// sealParallel encrypts plaintext into records and appends them to dst, like sealRecords with final set.
+// The records are sealed by up to workers goroutines.
+func (s *encryptState) sealParallel(dst, plaintext []byte, workers int) ([]byte, error) {
+	var (
+		plaintextLen = len(plaintext)
+		perJob       = max(1, parallelChunkSize/int(s.opt.recordSize))
+		jobs         []encryptJob
+		t            = *s
+		start        = 0
+		offset       = len(dst)
+	)
+
+	// Lay out the records, and cut them into jobs.
+	for !t.done {
+		job := encryptJob{state: t, start: start, offset: offset}
+		for ; job.count < perJob && !t.done; job.count++ {
+			end, recordPad, last := t.layout(start, plaintextLen)
+			end = min(end, plaintextLen)
+			offset += t.recordLen(end-start, recordPad)
+			t.padSize -= recordPad
+			t.counter++
+			t.done = last
+			start = end
+		}
+		jobs = append(jobs, job)
+	}
+
+	if len(jobs) < 2 {
+		dst, _, err := s.sealRecords(dst, plaintext, true)
+		return dst, err
+	}
+
+	out := slices.Grow(dst, offset-len(dst))[:offset]
+	errs := make([]error, len(jobs))
+	runParallel(len(jobs), workers, func(i int) {
+		errs[i] = jobs[i].seal(out, plaintext)
+	})
+	for _, err := range errs {
+		if err != nil {
+			return dst, err
+		}
+	}
+
+	*s = t
+	return out, nil
+}
+
+// seal seals the records of the job into out.
+func (j *encryptJob) seal(out, plaintext []byte) error {
+	var (
+		state        = j.state
+		start        = j.start
+		offset       = j.offset
+		plaintextLen = len(plaintext)
+	)
+
+	for range j.count {
+		end, recordPad, last := state.layout(start, plaintextLen)
+		end = min(end, plaintextLen)
+		// out has room for the record, so it is sealed in place.
+		r, err := state.sealRecord(out[offset:offset], plaintext[start:end], recordPad, last)
+		if err != nil {
+			return err
+		}
+		offset += len(r)
+		start = end
+	}
+	return nil
+}
+
+// runParallel calls fn for 0 to n-1 on up to workers goroutines.
+func runParallel(n, workers int, fn func(i int)) {
+	var (
+		next atomic.Int64
+		wg   sync.WaitGroup
+	)
+	for range min(workers, n) {
+		wg.Go(func() {
+			for i := int(next.Add(1)) - 1; i < n; i = int(next.Add(1)) - 1 {
+				fn(i)
+			}
+		})
+	}
+	wg.Wait()
+}
@@ -0,0 +1,68 @@
+/*
+ * Copyright (c) 2019 Zenichi Amano
+ *
+ * This file is part of http-ece, which is MIT licensed.
+ * See http://opensource.org/licenses/MIT
+ */
+
+package httpece
+
+import (
+	"fmt"
+	"runtime"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEncryptParallel(t *testing.T) {
+	key := []byte("0123456789abcdef")
+	salt := d(t, "mRGYnIzSJGeZnJ19lgQcfw==")
+	plaintext := make([]byte, 1<<20)
+	for i := range plaintext {
+		plaintext[i] = byte(i)
+	}
+
+	for _, encoding := range []ContentEncoding{AES128GCM, AESGCM} {
+		for _, size := range []int{0, 100, 1 << 20} {
+			for _, recordSize := range []int{55, 4096} {
+				for _, padSize := range []int{0, 1, 300000} {
+					t.Run(fmt.Sprintf("%s/%d/%d/%d", encoding, size, recordSize, padSize), func(t *testing.T) {
+						opts := []Option{
+							WithEncoding(encoding),
+							WithKey(key),
+							WithKeyID([]byte("a1")),
+							WithSalt(salt),
+							WithRecordSize(recordSize),
+							WithPadSize(padSize),
+						}
+						expected, err := Encrypt(plaintext[:size], opts...)
+						assert.Nil(t, err)
+
+						content, err := Encrypt(plaintext[:size], append(opts, WithConcurrency(4))...)
+						assert.Nil(t, err)
+						assert.Equal(t, expected, content)
+					})
+				}
+			}
+		}
+	}
+}
+
+func BenchmarkEncryptParallel(b *testing.B) {
+	key := []byte("0123456789abcdef")
+	plaintext := make([]byte, 64<<20)
+	dst := make([]byte, 0, 65<<20)
+
+	b.ReportAllocs()
+	b.SetBytes(int64(len(plaintext)))
+	b.ResetTimer()
+
+	var err error
+	for i := 0; i < b.N; i++ {
+		_, err = EncryptAppend(dst[:0], plaintext, WithKey(key), WithConcurrency(runtime.GOMAXPROCS(0)))
+	}
+	b.StopTimer()
+
+	assert.Nil(b, err)
+}
@@ -0,0 +1,94 @@
+/*
+ * Copyright (c) 2019 Zenichi Amano
+ *
+ * This file is part of http-ece, which is MIT licensed.
+ * See http://opensource.org/licenses/MIT
+ */
+
+package httpece
+
+import (
+	"errors"
+	"io"
+)
+
+type encryptReader struct {
+	src    io.Reader
+	state  *encryptState
+	buf    []byte // buffered plaintext
+	sealed []byte // sealed records
+	out    []byte // unread part of the ciphertext
+	err    error
+}
+
+// NewEncryptReader returns a reader that produces the encrypted form of the data read from src.
+// Errors in the options are reported by the first call to Read.
+// The output is identical to what Encrypt produces for the same plaintext and options.
+func NewEncryptReader(src io.Reader, opts ...Option) io.Reader {
+	opt, err := parseOptions(encrypt, opts)
+	if err != nil {
+		return &encryptReader{err: err}
+	}
+
+	return newEncryptReader(src, opt)
+}
+
+func newEncryptReader(src io.Reader, opt *options) io.Reader {
+	state, err := newEncryptState(opt)
+	if err != nil {
+		return &encryptReader{err: err}
+	}
+
+	header, err := writeHeader(state.opt, nil)
+	if err != nil {
+		return &encryptReader{err: err}
+	}
+
+	return &encryptReader{
+		src:   src,
+		state: state,
+		// One byte more than a record holds, to know whether another record follows.
+		buf: make([]byte, 0, state.baseRecordSize+1),
+		out: header,
+	}
+}
+
+func (r *encryptReader) Read(p []byte) (int, error) {
+	for len(r.out) == 0 {
+		if r.err != nil {
+			return 0, r.err
+		}
+		r.err = r.next()
+	}
+	n := copy(p, r.out)
+	r.out = r.out[n:]
+	if d := r.state.opt.digest; d != nil {
+		_, _ = d.Write(p[:n])
+	}
+	return n, nil
+}
+
+// next encrypts the records that can be completed with the data from src.
+func (r *encryptReader) next() error {
+	if r.state.done {
+		return io.EOF
+	}
+
+	n, err := io.ReadFull(r.src, r.buf[len(r.buf):cap(r.buf)])
+	r.buf = r.buf[:len(r.buf)+n]
+	final := false
+	if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		final = true
+	} else if err != nil {
+		return err
+	}
+
+	sealed, consumed, err := r.state.sealRecords(r.sealed[:0], r.buf, final)
+	if err != nil {
+		return err
+	}
+	r.sealed = sealed
+	r.out = sealed
+	r.buf = r.buf[:copy(r.buf, r.buf[consumed:])]
+	return nil
+}
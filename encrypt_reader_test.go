@@ -0,0 +1,64 @@
+/*
+ * Copyright (c) 2019 Zenichi Amano
+ *
+ * This file is part of http-ece, which is MIT licensed.
+ * See http://opensource.org/licenses/MIT
+ */
+
+package httpece
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+	"testing/iotest"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEncryptReader(t *testing.T) {
+	authSecret := d(t, "9HcXsQe3xLMG/w2HsYKrOA==")
+	salt := d(t, "mRGYnIzSJGeZnJ19lgQcfw==")
+	privateKey := d(t, "/oQYbac5yEOeOeg+5D0QxOaB1YtiyONxkqmxU3+tq58=")
+	peersPublicKey := d(t, "BBixsHNhTqN5jYhpguokCWQhGKoZlroyEj6GYr6hy79z1IeTEQdupEsW6xooLvM170j9Ekss3amjhOmDNP5Pi0s=")
+
+	for _, encoding := range []ContentEncoding{AES128GCM, AESGCM} {
+		for _, size := range []int{0, 1, 10, 37, 38, 39, 76, 1000} {
+			for _, padSize := range []int{0, 1, 50} {
+				t.Run(fmt.Sprintf("%s/%d/%d", encoding, size, padSize), func(t *testing.T) {
+					opts := []Option{
+						WithEncoding(encoding),
+						WithSalt(salt),
+						WithAuthSecret(authSecret),
+						WithPrivate(privateKey),
+						WithDh(peersPublicKey),
+						WithRecordSize(55),
+						WithPadSize(padSize),
+					}
+					plaintext := []byte(strings.Repeat("a", size))
+					expected, err := Encrypt(plaintext, opts...)
+					assert.Nil(t, err)
+
+					r := NewEncryptReader(iotest.OneByteReader(bytes.NewReader(plaintext)), opts...)
+					assert.Nil(t, iotest.TestReader(r, expected))
+				})
+			}
+		}
+	}
+}
+
+func TestEncryptReader_InvalidOption(t *testing.T) {
+	r := NewEncryptReader(strings.NewReader("test"), WithSalt([]byte("short")))
+	_, err := io.ReadAll(r)
+	assert.EqualError(t, err, "the salt parameter must be 16 bytes")
+}
+
+func TestEncryptReader_SourceError(t *testing.T) {
+	srcErr := errors.New("source error")
+	r := NewEncryptReader(iotest.ErrReader(srcErr), WithKey(make([]byte, 16)))
+	_, err := io.ReadAll(r)
+	assert.ErrorIs(t, err, srcErr)
+}
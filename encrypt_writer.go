@@ -0,0 +1,159 @@
+/*
+ * Copyright (c) 2019 Zenichi Amano
+ *
+ * This file is part of http-ece, which is MIT licensed.
+ * See http://opensource.org/licenses/MIT
+ */
+
+package httpece
+
+import (
+	"fmt"
+	"io"
+	"math"
+)
+
+type encryptWriter struct {
+	w      io.Writer
+	state  *encryptState
+	buf    []byte // buffered plaintext
+	out    []byte // sealed records waiting to be written
+	err    error
+	closed bool
+}
+
+// NewEncryptWriter returns a writer that encrypts data written to it and writes the result to w.
+// Records are emitted as soon as they are complete; Close must be called to write the last record.
+// The output is identical to what Encrypt produces for the same plaintext and options.
+func NewEncryptWriter(w io.Writer, opts ...Option) (io.WriteCloser, error) {
+	var opt *options
+	var err error
+
+	// Options
+	if opt, err = parseOptions(encrypt, opts); err != nil {
+		return nil, err
+	}
+
+	ew, err := newEncryptWriter(w, opt)
+	if err != nil {
+		return nil, err
+	}
+	return ew, nil
+}
+
+func newEncryptWriter(w io.Writer, opt *options) (*encryptWriter, error) {
+	state, err := newEncryptState(opt)
+	if err != nil {
+		return nil, err
+	}
+
+	header, err := writeHeader(state.opt, nil)
+	if err != nil {
+		return nil, err
+	}
+	ew := &encryptWriter{
+		w:     w,
+		state: state,
+		// One byte more than a record holds, to know whether another record follows.
+		buf: make([]byte, 0, state.baseRecordSize+1),
+	}
+	if err = ew.write(header); err != nil {
+		return nil, err
+	}
+	return ew, nil
+}
+
+func (w *encryptWriter) Write(p []byte) (int, error) {
+	if w.closed {
+		return 0, ErrClosed
+	}
+	if w.err != nil {
+		return 0, w.err
+	}
+
+	written := 0
+	for len(p) > 0 {
+		n := min(len(p), cap(w.buf)-len(w.buf))
+		w.buf = append(w.buf, p[:n]...)
+		p = p[n:]
+		written += n
+
+		if w.err = w.seal(false); w.err != nil {
+			return written, w.err
+		}
+	}
+	return written, nil
+}
+
+// Close encrypts the remaining data as the last record.
+// It does not close the underlying writer.
+func (w *encryptWriter) Close() error {
+	if w.closed {
+		return nil
+	}
+	w.closed = true
+	if w.err != nil {
+		return w.err
+	}
+	w.err = w.seal(true)
+	return w.err
+}
+
+// Flush encrypts the buffered data as a record padded to the full record size and writes it,
+// so that everything written so far can be decrypted. Close must still be called to write the last record.
+// With aesgcm the padding of a record is limited to 65535 bytes; if the buffered data is too short to fill
+// a record, Flush returns ErrShortFlush and writes nothing, and the writer remains usable.
+func (w *encryptWriter) Flush() error {
+	if w.closed {
+		return ErrClosed
+	}
+	if w.err != nil {
+		return w.err
+	}
+	if len(w.buf) == 0 {
+		return nil
+	}
+	// A non-last aesgcm record has the full record size, and its padding length is a uint16.
+	if pad := w.state.baseRecordSize - len(w.buf); w.state.opt.encoding == AESGCM && pad > math.MaxUint16 {
+		return fmt.Errorf("%w: padding size %d exceeds uint16 limit", ErrShortFlush, pad)
+	}
+	w.err = w.flush()
+	return w.err
+}
+
+func (w *encryptWriter) flush() error {
+	s := w.state
+	pad := s.baseRecordSize - len(w.buf)
+	padSize := s.padSize
+	out, err := s.sealRecord(w.out[:0], w.buf, pad, false)
+	w.out = out
+	if err != nil {
+		return err
+	}
+	// The padding of the flushed record counts towards the padding size.
+	s.padSize = max(padSize-pad, 0)
+	w.buf = w.buf[:0]
+	return w.write(out)
+}
+
+func (w *encryptWriter) seal(final bool) error {
+	out, n, err := w.state.sealRecords(w.out[:0], w.buf, final)
+	w.out = out
+	if err != nil {
+		return err
+	}
+	w.buf = w.buf[:copy(w.buf, w.buf[n:])]
+	if len(out) == 0 {
+		return nil
+	}
+	return w.write(out)
+}
+
+// write writes ciphertext to the underlying writer.
+func (w *encryptWriter) write(b []byte) error {
+	if d := w.state.opt.digest; d != nil {
+		_, _ = d.Write(b)
+	}
+	_, err := w.w.Write(b)
+	return err
+}
@@ -0,0 +1,158 @@
+/*
+ * Copyright (c) 2019 Zenichi Amano
+ *
+ * This file is part of http-ece, which is MIT licensed.
+ * See http://opensource.org/licenses/MIT
+ */
+
+package httpece
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEncryptWriter(t *testing.T) {
+	authSecret := d(t, "9HcXsQe3xLMG/w2HsYKrOA==")
+	salt := d(t, "mRGYnIzSJGeZnJ19lgQcfw==")
+	privateKey := d(t, "/oQYbac5yEOeOeg+5D0QxOaB1YtiyONxkqmxU3+tq58=")
+	peersPublicKey := d(t, "BBixsHNhTqN5jYhpguokCWQhGKoZlroyEj6GYr6hy79z1IeTEQdupEsW6xooLvM170j9Ekss3amjhOmDNP5Pi0s=")
+
+	for _, encoding := range []ContentEncoding{AES128GCM, AESGCM} {
+		for _, size := range []int{0, 1, 10, 37, 38, 39, 76, 1000} {
+			for _, padSize := range []int{0, 1, 50} {
+				for _, chunk := range []int{1, 7, 64, 4096} {
+					t.Run(fmt.Sprintf("%s/%d/%d/%d", encoding, size, padSize, chunk), func(t *testing.T) {
+						opts := []Option{
+							WithEncoding(encoding),
+							WithSalt(salt),
+							WithAuthSecret(authSecret),
+							WithPrivate(privateKey),
+							WithDh(peersPublicKey),
+							WithRecordSize(55),
+							WithPadSize(padSize),
+						}
+						plaintext := []byte(strings.Repeat("a", size))
+						expected, err := Encrypt(plaintext, opts...)
+						assert.Nil(t, err)
+
+						var buf bytes.Buffer
+						w, err := NewEncryptWriter(&buf, opts...)
+						assert.Nil(t, err)
+						for p := plaintext; len(p) > 0; {
+							n := min(chunk, len(p))
+							written, err := w.Write(p[:n])
+							assert.Nil(t, err)
+							assert.Equal(t, n, written)
+							p = p[n:]
+						}
+						assert.Nil(t, w.Close())
+						assert.Equal(t, expected, buf.Bytes())
+					})
+				}
+			}
+		}
+	}
+}
+
+func TestEncryptWriter_WriteAfterClose(t *testing.T) {
+	var buf bytes.Buffer
+	w, err := NewEncryptWriter(&buf, WithKey(make([]byte, 16)))
+	assert.Nil(t, err)
+	assert.Nil(t, w.Close())
+	assert.Nil(t, w.Close())
+
+	_, err = w.Write([]byte("test"))
+	assert.ErrorIs(t, err, ErrClosed)
+
+	plaintext, err := Decrypt(buf.Bytes(), WithKey(make([]byte, 16)))
+	assert.Nil(t, err)
+	assert.Empty(t, plaintext)
+}
+
+func TestEncryptWriter_Flush(t *testing.T) {
+	key := []byte("0123456789abcdef")
+
+	for _, encoding := range []ContentEncoding{AES128GCM, AESGCM} {
+		for _, padSize := range []int{0, 20} {
+			t.Run(fmt.Sprintf("%s/%d", encoding, padSize), func(t *testing.T) {
+				opts := []Option{
+					WithEncoding(encoding),
+					WithKey(key),
+					WithKeyID([]byte("a1")),
+					WithSalt(d(t, "mRGYnIzSJGeZnJ19lgQcfw==")),
+					WithRecordSize(55),
+					WithPadSize(padSize),
+				}
+				var buf bytes.Buffer
+				w, err := NewEncryptWriter(&buf, opts...)
+				assert.Nil(t, err)
+				flusher := w.(interface{ Flush() error })
+
+				_, err = w.Write([]byte("hello"))
+				assert.Nil(t, err)
+				assert.Nil(t, flusher.Flush())
+				// The flushed record is padded to the full record size.
+				if encoding == AES128GCM {
+					assert.Equal(t, headerLenMin+2+55, buf.Len())
+				} else {
+					assert.Equal(t, 55+tagLen, buf.Len())
+				}
+
+				// Flushing again writes nothing.
+				assert.Nil(t, flusher.Flush())
+				_, err = w.Write([]byte(strings.Repeat("b", 100)))
+				assert.Nil(t, err)
+				assert.Nil(t, flusher.Flush())
+				assert.Nil(t, w.Close())
+				assert.ErrorIs(t, flusher.Flush(), ErrClosed)
+
+				plaintext, err := Decrypt(buf.Bytes(), opts...)
+				assert.Nil(t, err)
+				assert.Equal(t, "hello"+strings.Repeat("b", 100), string(plaintext))
+			})
+		}
+	}
+}
+
+func TestEncryptWriter_FlushLargeRecordSize(t *testing.T) {
+	key := []byte("0123456789abcdef")
+	for _, encoding := range []ContentEncoding{AES128GCM, AESGCM} {
+		t.Run(string(encoding), func(t *testing.T) {
+			opts := []Option{
+				WithEncoding(encoding),
+				WithKey(key),
+				WithKeyID([]byte("a1")),
+				WithSalt(d(t, "mRGYnIzSJGeZnJ19lgQcfw==")),
+				WithRecordSize(100000),
+			}
+			var buf bytes.Buffer
+			w, err := NewEncryptWriter(&buf, opts...)
+			assert.Nil(t, err)
+			flusher := w.(interface{ Flush() error })
+
+			_, err = w.Write([]byte("hello"))
+			assert.Nil(t, err)
+			err = flusher.Flush()
+			if encoding == AES128GCM {
+				assert.Nil(t, err)
+			} else {
+				// The padding of a full record does not fit, and the writer stays usable.
+				assert.ErrorIs(t, err, ErrShortFlush)
+			}
+			more := strings.Repeat("b", 50000)
+			_, err = w.Write([]byte(more))
+			assert.Nil(t, err)
+			assert.Nil(t, flusher.Flush())
+			assert.Nil(t, w.Close())
+
+			plaintext, err := Decrypt(buf.Bytes(), opts...)
+			assert.Nil(t, err)
+			assert.Equal(t, "hello"+more, string(plaintext))
+		})
+	}
+}
@@ -0,0 +1,112 @@
+/*
+ * Copyright (c) 2019 Zenichi Amano
+ *
+ * This file is part of http-ece, which is MIT licensed.
+ * See http://opensource.org/licenses/MIT
+ */
+
+package httpece
+
+import (
+	"errors"
+	"io"
+)
+
+// Encryptor encrypts messages with options that are validated once.
+// It is safe for concurrent use.
+type Encryptor struct {
+	opt *options
+}
+
+// NewEncryptor returns an Encryptor for the options.
+//
+// Unless a private key is given, a new DH key pair is generated for every message.
+// A new salt is generated for every message, so that no two messages share a content encryption key
+// and nonces; ECDH shared secrets are cached.
+// WithCryptoHeaders and WithDigest are only accepted per message, and so are WithSalt and WithHeader.
+func NewEncryptor(opts ...Option) (*Encryptor, error) {
+	e, err := newEncryptor(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	// Check the options by encrypting an empty message.
+	if _, err = e.Encrypt(nil); err != nil {
+		return nil, err
+	}
+
+	return e, nil
+}
+
+// newEncryptor returns an Encryptor for options that may lack the key, which is then given per message.
+func newEncryptor(opts []Option) (*Encryptor, error) {
+	var opt *options
+	var err error
+
+	// Options
+	if opt, err = parseOptions(encrypt, opts); err != nil {
+		return nil, err
+	}
+	if err = opt.checkShared(); err != nil {
+		return nil, err
+	}
+	if len(opt.salt) > 0 || opt.header != nil {
+		return nil, errors.New("a fixed salt reuses nonces across messages: WithSalt and WithHeader have to be given per message")
+	}
+
+	if !opt.ephemeral {
+		opt.secretCache = newLRUCache[[]byte](opt.cacheSize)
+	}
+	return &Encryptor{opt: opt}, nil
+}
+
+// Encrypt encrypts plaintext data.
+// opts override the options of the Encryptor for this message.
+func (e *Encryptor) Encrypt(plaintext []byte, opts ...Option) ([]byte, error) {
+	opt, err := e.options(opts)
+	if err != nil {
+		return nil, err
+	}
+	return encryptContent(nil, opt, plaintext)
+}
+
+// NewWriter returns a writer that encrypts data written to it, like NewEncryptWriter.
+// opts override the options of the Encryptor for this message.
+func (e *Encryptor) NewWriter(w io.Writer, opts ...Option) (io.WriteCloser, error) {
+	opt, err := e.options(opts)
+	if err != nil {
+		return nil, err
+	}
+	ew, err := newEncryptWriter(w, opt)
+	if err != nil {
+		return nil, err
+	}
+	return ew, nil
+}
+
+// NewReader returns a reader that encrypts data read from src, like NewEncryptReader.
+// opts override the options of the Encryptor for this message.
+func (e *Encryptor) NewReader(src io.Reader, opts ...Option) io.Reader {
+	opt, err := e.options(opts)
+	if err != nil {
+		return &encryptReader{err: err}
+	}
+	return newEncryptReader(src, opt)
+}
+
+// options returns the options for a message.
+func (e *Encryptor) options(opts []Option) (*options, error) {
+	opt, err := e.opt.clone(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	if opt.ephemeral && opt.dh != nil {
+		if opt.privateKey, err = randomKey(); err != nil {
+			return nil, err
+		}
+		opt.publicKey = opt.privateKey.PublicKey()
+		opt.secretCache = nil
+	}
+	return opt, nil
+}
@@ -0,0 +1,130 @@
+/*
+ * Copyright (c) 2019 Zenichi Amano
+ *
+ * This file is part of http-ece, which is MIT licensed.
+ * See http://opensource.org/licenses/MIT
+ */
+
+package httpece
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEncryptor(t *testing.T) {
+	authSecret := d(t, "9HcXsQe3xLMG/w2HsYKrOA==")
+	privateKey := d(t, "/oQYbac5yEOeOeg+5D0QxOaB1YtiyONxkqmxU3+tq58=")
+	peersPublicKey := d(t, "BBixsHNhTqN5jYhpguokCWQhGKoZlroyEj6GYr6hy79z1IeTEQdupEsW6xooLvM170j9Ekss3amjhOmDNP5Pi0s=")
+	receiverPrivateKey := d(t, "yfSYB+/vCEoWklHCG7F99cQ1vRwemFYn87jZc8PHBwU=")
+	plaintext := []byte(strings.Repeat("a", 5000))
+
+	e, err := NewEncryptor(WithEncoding(AES128GCM), WithAuthSecret(authSecret), WithPrivate(privateKey), WithDh(peersPublicKey))
+	assert.Nil(t, err)
+
+	contents := make([][]byte, 8)
+	var wg sync.WaitGroup
+	for i := range contents {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			content, err := e.Encrypt(plaintext)
+			assert.Nil(t, err)
+			contents[i] = content
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, 1, e.opt.secretCache.len())
+
+	var buf bytes.Buffer
+	w, err := e.NewWriter(&buf)
+	assert.Nil(t, err)
+	_, err = w.Write(plaintext)
+	assert.Nil(t, err)
+	assert.Nil(t, w.Close())
+	content, err := io.ReadAll(e.NewReader(bytes.NewReader(plaintext)))
+	assert.Nil(t, err)
+	contents = append(contents, buf.Bytes(), content)
+
+	// Every message has a salt, and so a key and nonces, of its own.
+	salts := map[string]bool{}
+	for _, content := range contents {
+		header, _, err := ParseHeader(content)
+		assert.Nil(t, err)
+		salts[string(header.Salt)] = true
+
+		result, err := Decrypt(content, WithAuthSecret(authSecret), WithPrivate(receiverPrivateKey))
+		assert.Nil(t, err)
+		assert.Equal(t, plaintext, result)
+	}
+	assert.Len(t, salts, len(contents))
+}
+
+func TestEncryptor_EphemeralKey(t *testing.T) {
+	authSecret := d(t, "9HcXsQe3xLMG/w2HsYKrOA==")
+	peersPublicKey := d(t, "BBixsHNhTqN5jYhpguokCWQhGKoZlroyEj6GYr6hy79z1IeTEQdupEsW6xooLvM170j9Ekss3amjhOmDNP5Pi0s=")
+	receiverPrivateKey := d(t, "yfSYB+/vCEoWklHCG7F99cQ1vRwemFYn87jZc8PHBwU=")
+
+	e, err := NewEncryptor(WithAuthSecret(authSecret), WithDh(peersPublicKey))
+	assert.Nil(t, err)
+
+	content1, err := e.Encrypt([]byte("test"))
+	assert.Nil(t, err)
+	content2, err := e.Encrypt([]byte("test"))
+	assert.Nil(t, err)
+
+	// The sender public key in the header differs.
+	headerLen := keyLen + recodeSizeLen + 1
+	assert.NotEqual(t, content1[headerLen:headerLen+65], content2[headerLen:headerLen+65])
+
+	for _, content := range [][]byte{content1, content2} {
+		plaintext, err := Decrypt(content, WithAuthSecret(authSecret), WithPrivate(receiverPrivateKey))
+		assert.Nil(t, err)
+		assert.Equal(t, "test", string(plaintext))
+	}
+}
+
+func TestEncryptor_InvalidOption(t *testing.T) {
+	e, err := NewEncryptor(WithKey(make([]byte, 16)), WithSalt(make([]byte, 16)))
+	assert.NotNil(t, err)
+	assert.Nil(t, e)
+	_, err = NewEncryptor(WithKey(make([]byte, 16)), WithHeader(Header{Salt: make([]byte, 16), RecordSize: 4096}))
+	assert.NotNil(t, err)
+
+	e, err = NewEncryptor(WithKey(make([]byte, 16)))
+	assert.Nil(t, err)
+	_, err = e.Encrypt([]byte("test"), WithSalt([]byte("short")))
+	assert.EqualError(t, err, "the salt parameter must be 16 bytes")
+	_, err = e.Encrypt([]byte("test"), WithRecordSize(10))
+	assert.EqualError(t, err, "recordSize has to be greater than 17")
+}
+
+func TestEncryptor_CryptoHeaders(t *testing.T) {
+	key := make([]byte, 16)
+	var headers CryptoHeaders
+	_, err := NewEncryptor(WithEncoding(AESGCM), WithKey(key), WithCryptoHeaders(&headers))
+	assert.NotNil(t, err)
+	assert.Equal(t, CryptoHeaders{}, headers)
+
+	e, err := NewEncryptor(WithEncoding(AESGCM), WithKey(key))
+	assert.Nil(t, err)
+	var wg sync.WaitGroup
+	for range 8 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			var headers CryptoHeaders
+			content, err := e.Encrypt([]byte("test"), WithCryptoHeaders(&headers))
+			assert.Nil(t, err)
+			plaintext, err := Decrypt(content, WithEncoding(AESGCM), WithKey(key), WithSalt(headers.Encryption[0].Salt))
+			assert.Nil(t, err)
+			assert.Equal(t, "test", string(plaintext))
+		}()
+	}
+	wg.Wait()
+}
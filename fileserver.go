@@ -0,0 +1,114 @@
+/*
+ * Copyright (c) 2019 Zenichi Amano
+ *
+ * This file is part of http-ece, which is MIT licensed.
+ * See http://opensource.org/licenses/MIT
+ */
+
+package httpece
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/fs"
+	"mime"
+	"net/http"
+	"path"
+	"strings"
+)
+
+// fileExtension is the extension of files that hold aes128gcm content.
+const fileExtension = ".ece"
+
+// FileServer returns a handler that serves files encrypted with aes128gcm from fsys, like http.FileServerFS.
+//
+// A request for foo is answered with the content of foo.ece: as is with Content-Encoding aes128gcm
+// for clients that accept it, and decrypted with the options of fn added to opts otherwise.
+// Without fn, such clients get 406. The Content-Type follows the extension of foo, and
+// conditional and Range requests are handled by ServeContent. Directories are not listed.
+//
+// Files may be padded; their records are indexed once per modification time, see Decryptor.ServeContent.
+// WithUnpaddedRecords(true) avoids that for files known to be free of padding.
+// Invalid opts make every request fail with 500.
+func FileServer(fsys fs.FS, fn RequestOptionsFunc, opts ...Option) http.Handler {
+	dec, err := NewDecryptor(opts...)
+	if err != nil {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		})
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		name := strings.TrimPrefix(path.Clean("/"+r.URL.Path), "/")
+		if name == "" || strings.HasSuffix(r.URL.Path, "/") {
+			http.NotFound(w, r)
+			return
+		}
+
+		f, err := fsys.Open(name + fileExtension)
+		if err != nil {
+			serveFileError(w, r, err)
+			return
+		}
+		defer f.Close()
+
+		info, err := f.Stat()
+		if err != nil {
+			serveFileError(w, r, err)
+			return
+		}
+		if info.IsDir() {
+			http.NotFound(w, r)
+			return
+		}
+
+		addVary(w.Header(), headerAcceptEncoding)
+		var reqOpts []Option
+		mode := RangePlaintext
+		if acceptsCoding(r.Header.Values(headerAcceptEncoding), string(AES128GCM)) {
+			mode = RangeCiphertext
+		} else {
+			if fn == nil {
+				http.Error(w, http.StatusText(http.StatusNotAcceptable), http.StatusNotAcceptable)
+				return
+			}
+			if reqOpts, err = fn(r); err != nil {
+				http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+				return
+			}
+		}
+
+		content, ok := f.(io.ReaderAt)
+		if !ok {
+			b, err := io.ReadAll(f)
+			if err != nil {
+				serveFileError(w, r, err)
+				return
+			}
+			content = bytes.NewReader(b)
+		}
+
+		contentType := mime.TypeByExtension(path.Ext(name))
+		if contentType == "" && mode == RangeCiphertext {
+			// Sniffing the ciphertext tells nothing.
+			contentType = "application/octet-stream"
+		}
+		if contentType != "" {
+			w.Header().Set("Content-Type", contentType)
+		}
+		dec.ServeContent(w, r, name, info.ModTime(), content, info.Size(), mode, reqOpts...)
+	})
+}
+
+// serveFileError replies with the status for an error opening a file.
+func serveFileError(w http.ResponseWriter, r *http.Request, err error) {
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+		http.NotFound(w, r)
+	case errors.Is(err, fs.ErrPermission):
+		http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+	default:
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,231 @@
+/*
+ * Copyright (c) 2019 Zenichi Amano
+ *
+ * This file is part of http-ece, which is MIT licensed.
+ * See http://opensource.org/licenses/MIT
+ */
+
+package httpece
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFileServer(t *testing.T) {
+	key := []byte("0123456789abcdef")
+	modTime := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	content, err := Encrypt([]byte("hello world"), WithKey(key), WithRecordSize(100))
+	assert.Nil(t, err)
+	fsys := fstest.MapFS{
+		"docs/a.txt.ece": {Data: content, ModTime: modTime},
+		"docs/b.ece":     {Data: content, ModTime: modTime},
+		"docs/c.txt":     {Data: []byte("plain")},
+		"dir.ece/x":      {Data: content},
+	}
+	handler := FileServer(fsys, func(r *http.Request) ([]Option, error) {
+		return []Option{WithKey(key)}, nil
+	})
+
+	serve := func(target string, header http.Header) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, target, nil)
+		for name, values := range header {
+			req.Header[name] = values
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec := serve("/docs/a.txt", http.Header{"Accept-Encoding": {"aes128gcm"}})
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, content, rec.Body.Bytes())
+	assert.Equal(t, "aes128gcm", rec.Header().Get(headerContentEncoding))
+	assert.Equal(t, "text/plain; charset=utf-8", rec.Header().Get("Content-Type"))
+	assert.Equal(t, headerAcceptEncoding, rec.Header().Get(headerVary))
+
+	rec = serve("/docs/a.txt", nil)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "hello world", rec.Body.String())
+	assert.Equal(t, "", rec.Header().Get(headerContentEncoding))
+	assert.Equal(t, "text/plain; charset=utf-8", rec.Header().Get("Content-Type"))
+
+	rec = serve("/docs/a.txt", http.Header{"Range": {"bytes=6-"}})
+	assert.Equal(t, http.StatusPartialContent, rec.Code)
+	assert.Equal(t, "world", rec.Body.String())
+
+	rec = serve("/docs/a.txt", http.Header{"If-Modified-Since": {modTime.Format(http.TimeFormat)}})
+	assert.Equal(t, http.StatusNotModified, rec.Code)
+
+	rec = serve("/docs/b", http.Header{"Accept-Encoding": {"aes128gcm"}})
+	assert.Equal(t, "application/octet-stream", rec.Header().Get("Content-Type"))
+	rec = serve("/docs/b", nil)
+	assert.Equal(t, "text/plain; charset=utf-8", rec.Header().Get("Content-Type"))
+
+	for _, target := range []string{"/docs/c.txt", "/docs/a.txt.ece", "/docs/", "/", "/dir", "/docs/a.txt/"} {
+		rec = serve(target, nil)
+		assert.Equal(t, http.StatusNotFound, rec.Code, target)
+	}
+}
+
+func TestFileServer_Padded(t *testing.T) {
+	key := []byte("0123456789abcdef")
+	plaintext := make([]byte, 300)
+	for i := range plaintext {
+		plaintext[i] = 'a' + byte(i%26)
+	}
+	padded, err := Encrypt(plaintext, WithKey(key), WithRecordSize(100), WithPadSize(50))
+	assert.Nil(t, err)
+	var flushed bytes.Buffer
+	w, err := NewEncryptWriter(&flushed, WithKey(key), WithRecordSize(100))
+	assert.Nil(t, err)
+	for _, chunk := range [][]byte{plaintext[:30], plaintext[30:]} {
+		_, err = w.Write(chunk)
+		assert.Nil(t, err)
+		assert.Nil(t, w.(interface{ Flush() error }).Flush())
+	}
+	assert.Nil(t, w.Close())
+
+	handler := FileServer(fstest.MapFS{
+		"padded.ece":  {Data: padded},
+		"flushed.ece": {Data: flushed.Bytes()},
+	}, func(r *http.Request) ([]Option, error) {
+		return []Option{WithKey(key)}, nil
+	})
+	for _, target := range []string{"/padded", "/flushed"} {
+		req := httptest.NewRequest(http.MethodGet, target, nil)
+		req.Header.Set("Range", "bytes=100-120")
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		assert.Equal(t, http.StatusPartialContent, rec.Code, target)
+		assert.Equal(t, "bytes 100-120/300", rec.Header().Get("Content-Range"), target)
+		assert.Equal(t, plaintext[100:121], rec.Body.Bytes(), target)
+	}
+}
+
+// countingFS counts the reads of the files it opens.
+type countingFS struct {
+	fs.FS
+	reads int
+}
+
+func (f *countingFS) Open(name string) (fs.File, error) {
+	file, err := f.FS.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	return countingFile{File: file, fsys: f}, nil
+}
+
+type countingFile struct {
+	fs.File
+	fsys *countingFS
+}
+
+func (f countingFile) ReadAt(p []byte, off int64) (int, error) {
+	f.fsys.reads++
+	return f.File.(io.ReaderAt).ReadAt(p, off)
+}
+
+func TestFileServer_PaddedIndex(t *testing.T) {
+	key := []byte("0123456789abcdef")
+	plaintext := make([]byte, 1000)
+	padded, err := Encrypt(plaintext, WithKey(key), WithRecordSize(100), WithPadSize(50))
+	assert.Nil(t, err)
+	modTime := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	fsys := &countingFS{FS: fstest.MapFS{"a.txt.ece": {Data: padded, ModTime: modTime}}}
+	handler := FileServer(fsys, func(r *http.Request) ([]Option, error) {
+		return []Option{WithKey(key)}, nil
+	})
+
+	serve := func() {
+		req := httptest.NewRequest(http.MethodGet, "/a.txt", nil)
+		req.Header.Set("Range", "bytes=10-20")
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		assert.Equal(t, http.StatusPartialContent, rec.Code)
+		assert.Equal(t, "bytes 10-20/1000", rec.Header().Get("Content-Range"))
+	}
+
+	// The first request decrypts every record to learn the size.
+	serve()
+	assert.Greater(t, fsys.reads, 10)
+	// Later requests decrypt the header and the record holding the range only.
+	fsys.reads = 0
+	serve()
+	assert.Equal(t, 3, fsys.reads)
+
+	// A changed file is indexed again.
+	fsys.FS.(fstest.MapFS)["a.txt.ece"].ModTime = modTime.Add(time.Second)
+	fsys.reads = 0
+	serve()
+	assert.Greater(t, fsys.reads, 10)
+}
+
+func TestFileServer_WrongKey(t *testing.T) {
+	key := []byte("0123456789abcdef")
+	content, err := Encrypt(make([]byte, 1000), WithKey(key), WithRecordSize(100), WithPadSize(50))
+	assert.Nil(t, err)
+	modTime := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	wrongKey := false
+	handler := FileServer(fstest.MapFS{"a.txt.ece": {Data: content, ModTime: modTime}}, func(r *http.Request) ([]Option, error) {
+		if wrongKey {
+			return []Option{WithKey([]byte("fedcba9876543210"))}, nil
+		}
+		return []Option{WithKey(key)}, nil
+	})
+
+	serve := func() *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/a.txt", nil))
+		return rec
+	}
+
+	// Index the file with the right key.
+	assert.Equal(t, http.StatusOK, serve().Code)
+
+	wrongKey = true
+	rec := serve()
+	assert.Equal(t, http.StatusInternalServerError, rec.Code)
+	assert.Equal(t, "", rec.Header().Get(headerContentLength))
+}
+
+func TestFileServer_NotAcceptable(t *testing.T) {
+	content, err := Encrypt([]byte("hello world"), WithKey([]byte("0123456789abcdef")))
+	assert.Nil(t, err)
+	handler := FileServer(fstest.MapFS{"a.ece": {Data: content}}, nil)
+
+	req := httptest.NewRequest(http.MethodGet, "/a", nil)
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusNotAcceptable, rec.Code)
+}
+
+type errorFS struct {
+	err error
+}
+
+func (f errorFS) Open(string) (fs.File, error) {
+	return nil, f.err
+}
+
+func TestFileServer_Errors(t *testing.T) {
+	for err, code := range map[error]int{
+		fs.ErrPermission:        http.StatusForbidden,
+		errors.New("I/O error"): http.StatusInternalServerError,
+	} {
+		req := httptest.NewRequest(http.MethodGet, "/a", nil)
+		rec := httptest.NewRecorder()
+		FileServer(errorFS{err: err}, nil).ServeHTTP(rec, req)
+		assert.Equal(t, code, rec.Code)
+	}
+}
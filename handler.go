@@ -0,0 +1,336 @@
+/*
+ * Copyright (c) 2019 Zenichi Amano
+ *
+ * This file is part of http-ece, which is MIT licensed.
+ * See http://opensource.org/licenses/MIT
+ */
+
+package httpece
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+const (
+	headerAcceptEncoding  = "Accept-Encoding"
+	headerAcceptRanges    = "Accept-Ranges"
+	headerContentEncoding = "Content-Encoding"
+	headerContentLength   = "Content-Length"
+	headerETag            = "ETag"
+	headerIfRange         = "If-Range"
+	headerRange           = "Range"
+	headerVary            = "Vary"
+	codingIdentity        = "identity"
+)
+
+// handlerRecordSizeMax is the largest record size DecryptRequestHandler accepts by default.
+const handlerRecordSizeMax = 1 << 20
+
+// RequestOptionsFunc returns the options for the message exchanged with r,
+// e.g. WithKey and WithKeyID for the client that sent it.
+type RequestOptionsFunc func(r *http.Request) ([]Option, error)
+
+// DecryptRequestHandler returns a handler that decrypts aes128gcm request bodies, and the codings applied
+// before encryption, while next reads them; their Content-Digest or Repr-Digest is checked at the end.
+// It answers 415 for other codings, and 400 for an invalid header or first record
+// or a record size above 1 MiB unless WithMaxRecordSize is given.
+func DecryptRequestHandler(next http.Handler, opts ...Option) http.Handler {
+	dec, decErr := NewDecryptor(pinEncoding(append([]Option{WithMaxRecordSize(handlerRecordSizeMax)}, opts...))...)
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		codings := parseCodings(r.Header.Values(headerContentEncoding))
+		if len(codings) == 0 {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if checkCodings(codings) != nil {
+			w.Header().Set(headerAcceptEncoding, strings.Join([]string{string(AES128GCM), codingGzip, codingDeflate}, ", "))
+			http.Error(w, http.StatusText(http.StatusUnsupportedMediaType), http.StatusUnsupportedMediaType)
+			return
+		}
+		if decErr != nil {
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+
+		rc, err := decodeBody(dec, verifyBody(r.Body, r.Header, r.Trailer, false), codings)
+		if err != nil {
+			http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+			return
+		}
+
+		r = r.Clone(r.Context())
+		r.Body = rc
+		r.ContentLength = -1
+		r.Header.Del(headerContentEncoding)
+		r.Header.Del(headerContentLength)
+		delDigest(r.Header, r.Trailer)
+		next.ServeHTTP(w, r)
+	})
+}
+
+// pinEncoding returns opts with the encoding set to aes128gcm, the Content-Encoding of the HTTP integration.
+// The HTTP helpers parse their options once and report errors in them per message:
+// handlers answer 500, and the proxy functions and Transport fail the exchange with the error.
+func pinEncoding(opts []Option) []Option {
+	return append(slices.Clip(opts), WithEncoding(AES128GCM))
+}
+
+// readCloser reads a transformed message body and closes the original one.
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
+
+// decodeBody returns a reader that removes the codings from body.
+// The first byte is decoded before returning, so that key and authentication errors are reported early.
+func decodeBody(dec *Decryptor, rc io.ReadCloser, codings []string) (io.ReadCloser, error) {
+	if rc == nil {
+		rc = http.NoBody
+	}
+	r, err := decodeReader(rc, codings, func(r io.Reader) (io.Reader, error) {
+		return dec.NewReader(r)
+	})
+	if err != nil {
+		return nil, err
+	}
+	first := make([]byte, 1)
+	n, err := io.ReadFull(r, first)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return nil, err
+	}
+	return readCloser{io.MultiReader(bytes.NewReader(first[:n]), r), rc}, nil
+}
+
+// EncryptResponseHandler returns a handler that encrypts the responses of next with aes128gcm
+// for clients that accept it, adding the options of fn for each request; fn may be nil.
+// Range requests are served in full, and Flush sends the buffered data as a padded record.
+func EncryptResponseHandler(next http.Handler, fn RequestOptionsFunc, opts ...Option) http.Handler {
+	enc, encErr := newEncryptor(pinEncoding(opts))
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		addVary(w.Header(), headerAcceptEncoding)
+		if !acceptsCoding(r.Header.Values(headerAcceptEncoding), string(AES128GCM)) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		err := encErr
+		var ew *encryptWriter
+		if err == nil {
+			ew, err = newResponseEncryptor(r, enc, fn)
+		}
+		if err != nil {
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+
+		r = r.Clone(r.Context())
+		r.Header.Del(headerRange)
+		r.Header.Del(headerIfRange)
+
+		rw := &encryptResponseWriter{ResponseWriter: w, head: r.Method == http.MethodHead, ew: ew}
+		if ew.state.opt.digestAlg != "" {
+			rw.digest = ew.state.opt.digest
+		}
+		if c := ew.state.opt.compression; c != "" && acceptsCoding(r.Header.Values(headerAcceptEncoding), c) {
+			rw.compression = c
+		}
+		defer rw.close()
+		next.ServeHTTP(rw, r)
+	})
+}
+
+// newResponseEncryptor returns an encrypt writer for the response to r.
+// The header of the content is kept in a buffer until the status is written.
+func newResponseEncryptor(r *http.Request, enc *Encryptor, fn RequestOptionsFunc) (*encryptWriter, error) {
+	var reqOpts []Option
+	if fn != nil {
+		var err error
+		if reqOpts, err = fn(r); err != nil {
+			return nil, err
+		}
+	}
+
+	opt, err := enc.options(pinEncoding(reqOpts))
+	if err != nil {
+		return nil, err
+	}
+	if opt.digestAlg != "" {
+		if opt.digest, err = NewDigest(opt.digestAlg); err != nil {
+			return nil, err
+		}
+	}
+	return newEncryptWriter(&bytes.Buffer{}, opt)
+}
+
+type encryptResponseWriter struct {
+	http.ResponseWriter
+	head        bool
+	compression string // coding applied before encryption, if any
+	ew          *encryptWriter
+	out         *codingWriter
+	digest      *Digest // sent as a trailer, if any
+	wroteHeader bool
+	encrypting  bool
+}
+
+func (w *encryptResponseWriter) WriteHeader(code int) {
+	if w.wroteHeader {
+		return
+	}
+	if code >= 100 && code <= 199 {
+		w.ResponseWriter.WriteHeader(code)
+		return
+	}
+	w.wroteHeader = true
+
+	h := w.Header()
+	if slices.Contains(parseCodings(h.Values(headerContentEncoding)), string(AES128GCM)) {
+		// next serves encrypted content itself.
+		w.ResponseWriter.WriteHeader(code)
+		return
+	}
+	weakenETag(h)
+	codings := []string{string(AES128GCM)}
+	if w.compression != "" && h.Get(headerContentEncoding) == "" {
+		// Compress unless next has applied a coding of its own.
+		codings = []string{w.compression, string(AES128GCM)}
+	}
+	if bodyAllowed(code) {
+		h.Add(headerContentEncoding, strings.Join(codings, ", "))
+		h.Del(headerContentLength)
+		h.Del(headerAcceptRanges)
+		delDigest(h, nil)
+		w.encrypting = !w.head
+		if w.encrypting && w.digest != nil {
+			h.Add(headerTrailer, headerContentDigest)
+		}
+	}
+	w.ResponseWriter.WriteHeader(code)
+
+	if w.encrypting {
+		// Send the header held back by newResponseEncryptor.
+		header := w.ew.w.(*bytes.Buffer)
+		w.ew.w = w.ResponseWriter
+		if _, err := w.ResponseWriter.Write(header.Bytes()); err != nil {
+			w.ew.err = err
+		}
+		// The codings are known to be supported.
+		w.out, _ = encodeWriter(w.ResponseWriter, codings, func(io.Writer) (io.WriteCloser, error) {
+			return w.ew, nil
+		})
+	}
+}
+
+func (w *encryptResponseWriter) Write(p []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	if !w.encrypting {
+		if w.head {
+			return len(p), nil
+		}
+		return w.ResponseWriter.Write(p)
+	}
+	return w.out.Write(p)
+}
+
+// Flush sends the data written so far to the client.
+func (w *encryptResponseWriter) Flush() {
+	_ = w.FlushError()
+}
+
+// FlushError sends the data written so far to the client, and returns the error if any.
+func (w *encryptResponseWriter) FlushError() error {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	if w.encrypting {
+		if err := w.out.Flush(); err != nil {
+			return err
+		}
+	}
+	return http.NewResponseController(w.ResponseWriter).Flush()
+}
+
+// Unwrap returns the underlying writer for http.ResponseController.
+func (w *encryptResponseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// close writes the last record after the handler has returned.
+func (w *encryptResponseWriter) close() {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	if w.encrypting {
+		// The response is already under way; a write error cannot be reported.
+		if w.out.Close() == nil && w.digest != nil {
+			w.Header().Set(headerContentDigest, w.digest.String())
+		}
+	}
+}
+
+// acceptsCoding reports whether the Accept-Encoding values give coding a non-zero weight.
+// As in RFC 9110 Section 12.5.3, an entry for coding wins over "*", whose weight applies otherwise.
+func acceptsCoding(values []string, coding string) bool {
+	wildcard := false
+	for _, value := range values {
+		for entry := range strings.SplitSeq(value, ",") {
+			name, params, _ := strings.Cut(entry, ";")
+			switch name = strings.TrimSpace(name); {
+			case strings.EqualFold(name, coding):
+				return qualityValue(params) > 0
+			case name == "*":
+				wildcard = qualityValue(params) > 0
+			}
+		}
+	}
+	return wildcard
+}
+
+// qualityValue returns the weight of the parameters of an Accept-Encoding entry.
+func qualityValue(params string) float64 {
+	for param := range strings.SplitSeq(params, ";") {
+		name, value, _ := strings.Cut(param, "=")
+		if strings.EqualFold(strings.TrimSpace(name), "q") {
+			q, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+			if err != nil {
+				return 0
+			}
+			return q
+		}
+	}
+	return 1
+}
+
+// bodyAllowed reports whether a response with the status code has a body.
+func bodyAllowed(code int) bool {
+	return code != http.StatusNoContent && code != http.StatusNotModified
+}
+
+// addVary adds name to the Vary header unless it is listed already.
+func addVary(h http.Header, name string) {
+	for _, value := range h.Values(headerVary) {
+		for field := range strings.SplitSeq(value, ",") {
+			if field = strings.TrimSpace(field); field == "*" || strings.EqualFold(field, name) {
+				return
+			}
+		}
+	}
+	h.Add(headerVary, name)
+}
+
+// weakenETag marks a strong ETag as weak, since the encrypted representation differs per response.
+func weakenETag(h http.Header) {
+	if etag := h.Get(headerETag); etag != "" && !strings.HasPrefix(etag, "W/") {
+		h.Set(headerETag, "W/"+etag)
+	}
+}
@@ -0,0 +1,494 @@
+/*
+ * Copyright (c) 2019 Zenichi Amano
+ *
+ * This file is part of http-ece, which is MIT licensed.
+ * See http://opensource.org/licenses/MIT
+ */
+
+package httpece
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func echoHandler(t *testing.T) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		assert.Nil(t, r.Body.Close())
+		w.Header().Set("X-Content-Encoding", r.Header.Get(headerContentEncoding))
+		w.Header().Set("X-Content-Length", strconv.FormatInt(r.ContentLength, 10))
+		_, _ = w.Write(body)
+	})
+}
+
+func TestDecryptRequestHandler(t *testing.T) {
+	keys := map[string][]byte{
+		"a": []byte("0123456789abcdef"),
+		"b": []byte("fedcba9876543210"),
+	}
+	handler := DecryptRequestHandler(echoHandler(t), WithKeyMap(func(keyID []byte) []byte {
+		return keys[string(keyID)]
+	}))
+	plaintext := strings.Repeat("a", 10000)
+
+	for keyID, key := range keys {
+		content, err := Encrypt([]byte(plaintext), WithKey(key), WithKeyID([]byte(keyID)), WithRecordSize(1000))
+		assert.Nil(t, err)
+
+		req := httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(content))
+		req.Header.Set(headerContentEncoding, "aes128gcm")
+		req.Header.Set(headerContentLength, strconv.Itoa(len(content)))
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Equal(t, plaintext, rec.Body.String())
+		assert.Equal(t, "", rec.Header().Get("X-Content-Encoding"))
+		assert.Equal(t, "-1", rec.Header().Get("X-Content-Length"))
+	}
+}
+
+func TestDecryptRequestHandler_PassThrough(t *testing.T) {
+	handler := DecryptRequestHandler(echoHandler(t), WithKey([]byte("0123456789abcdef")))
+
+	for _, coding := range []string{"", "identity"} {
+		req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader("plain"))
+		if coding != "" {
+			req.Header.Set(headerContentEncoding, coding)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Equal(t, "plain", rec.Body.String())
+		assert.Equal(t, "5", rec.Header().Get("X-Content-Length"))
+	}
+}
+
+func TestDecryptRequestHandler_Errors(t *testing.T) {
+	key := []byte("0123456789abcdef")
+	handler := DecryptRequestHandler(echoHandler(t), WithKey(key))
+	content, err := Encrypt([]byte("plaintext"), WithKey([]byte("fedcba9876543210")))
+	assert.Nil(t, err)
+	uncompressed, err := Encrypt([]byte("plaintext"), WithKey(key))
+	assert.Nil(t, err)
+
+	tests := []struct {
+		name     string
+		coding   string
+		body     []byte
+		expected int
+	}{
+		{name: "wrong key", coding: "aes128gcm", body: content, expected: http.StatusBadRequest},
+		{name: "truncated", coding: "aes128gcm", body: content[:30], expected: http.StatusBadRequest},
+		{name: "empty", coding: "aes128gcm", body: nil, expected: http.StatusBadRequest},
+		{name: "unsupported", coding: "br", body: content, expected: http.StatusUnsupportedMediaType},
+		{name: "aesgcm", coding: "aesgcm", body: content, expected: http.StatusUnsupportedMediaType},
+		{name: "twice", coding: "aes128gcm, aes128gcm", body: content, expected: http.StatusUnsupportedMediaType},
+		{name: "not compressed", coding: "gzip, aes128gcm", body: uncompressed, expected: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(tt.body))
+			req.Header.Set(headerContentEncoding, tt.coding)
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			assert.Equal(t, tt.expected, rec.Code)
+			if tt.expected == http.StatusUnsupportedMediaType {
+				assert.Equal(t, "aes128gcm, gzip, deflate", rec.Header().Get(headerAcceptEncoding))
+			}
+		})
+	}
+}
+
+func TestDecryptRequestHandler_MaxRecordSize(t *testing.T) {
+	key := []byte("0123456789abcdef")
+	content, err := Encrypt([]byte("hello"), WithKey(key), WithKeyID([]byte("a1")))
+	assert.Nil(t, err)
+	// A forged header announces records of 2 GiB.
+	forged := bytes.Clone(content)
+	binary.BigEndian.PutUint32(forged[keyLen:], recordSizeMax)
+
+	serve := func(handler http.Handler, content []byte) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(content))
+		req.Header.Set(headerContentEncoding, "aes128gcm")
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	handler := DecryptRequestHandler(echoHandler(t), WithKey(key))
+	assert.Equal(t, http.StatusBadRequest, serve(handler, forged).Code)
+	rec := serve(handler, content)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "hello", rec.Body.String())
+
+	// A smaller limit rejects the default record size.
+	handler = DecryptRequestHandler(echoHandler(t), WithKey(key), WithMaxRecordSize(1024))
+	assert.Equal(t, http.StatusBadRequest, serve(handler, content).Code)
+
+	_, err = Decrypt(content, WithKey(key), WithMaxRecordSize(1024))
+	var headerErr *HeaderError
+	assert.True(t, errors.As(err, &headerErr), err)
+	assert.EqualError(t, err, "invalid header rs: record size 4096 exceeds 1024")
+}
+
+func TestDecryptRequestHandler_InvalidOptions(t *testing.T) {
+	handler := DecryptRequestHandler(echoHandler(t), WithPadSize(-1))
+
+	content, err := Encrypt([]byte("hello"), WithKey([]byte("0123456789abcdef")))
+	assert.Nil(t, err)
+	req := httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(content))
+	req.Header.Set(headerContentEncoding, "aes128gcm")
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusInternalServerError, rec.Code)
+
+	// Requests without Content-Encoding are passed through.
+	req = httptest.NewRequest(http.MethodPost, "/", strings.NewReader("hello"))
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusOK, rec.Code)
+}
+
+func TestDecryptRequestHandler_Encoding(t *testing.T) {
+	key := []byte("0123456789abcdef")
+	handler := DecryptRequestHandler(echoHandler(t), WithEncoding(AESGCM), WithKey(key))
+	content, err := Encrypt([]byte("hello"), WithKey(key))
+	assert.Nil(t, err)
+
+	req := httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(content))
+	req.Header.Set(headerContentEncoding, "aes128gcm")
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "hello", rec.Body.String())
+}
+
+func TestEncryptResponseHandler(t *testing.T) {
+	keys := map[string][]byte{
+		"a": []byte("0123456789abcdef"),
+		"b": []byte("fedcba9876543210"),
+	}
+	plaintext := strings.Repeat("a", 10000)
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set(headerContentLength, strconv.Itoa(len(plaintext)))
+		w.Header().Set(headerETag, `"v1"`)
+		_, _ = io.WriteString(w, plaintext)
+	})
+	handler := EncryptResponseHandler(next, func(r *http.Request) ([]Option, error) {
+		keyID := r.Header.Get("X-Key-ID")
+		return []Option{WithKey(keys[keyID]), WithKeyID([]byte(keyID))}, nil
+	}, WithRecordSize(1000))
+
+	for keyID, key := range keys {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set(headerAcceptEncoding, "gzip, aes128gcm")
+		req.Header.Set("X-Key-ID", keyID)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Equal(t, "aes128gcm", rec.Header().Get(headerContentEncoding))
+		assert.Equal(t, "", rec.Header().Get(headerContentLength))
+		assert.Equal(t, headerAcceptEncoding, rec.Header().Get(headerVary))
+		assert.Equal(t, `W/"v1"`, rec.Header().Get(headerETag))
+
+		result, err := Decrypt(rec.Body.Bytes(), WithKey(key))
+		assert.Nil(t, err)
+		assert.Equal(t, plaintext, string(result))
+	}
+}
+
+func TestEncryptResponseHandler_NotAccepted(t *testing.T) {
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set(headerETag, `"v1"`)
+		_, _ = io.WriteString(w, "plain")
+	})
+	handler := EncryptResponseHandler(next, nil, WithKey([]byte("0123456789abcdef")))
+
+	for _, accept := range []string{"", "gzip", "aes128gcm;q=0", "*;q=0", "aes128gcm;q=0, *"} {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set(headerAcceptEncoding, accept)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+
+		assert.Equal(t, "plain", rec.Body.String())
+		assert.Equal(t, "", rec.Header().Get(headerContentEncoding))
+		assert.Equal(t, headerAcceptEncoding, rec.Header().Get(headerVary))
+		assert.Equal(t, `"v1"`, rec.Header().Get(headerETag))
+	}
+
+	// The weight of "*" applies to aes128gcm.
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set(headerAcceptEncoding, "gzip, *")
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	assert.Equal(t, "aes128gcm", rec.Header().Get(headerContentEncoding))
+}
+
+func TestEncryptResponseHandler_Encrypted(t *testing.T) {
+	key := []byte("0123456789abcdef")
+	content, err := Encrypt([]byte("hello world"), WithKey(key))
+	assert.Nil(t, err)
+	files := FileServer(fstest.MapFS{"a.txt.ece": {Data: content}}, nil)
+	handler := EncryptResponseHandler(files, nil, WithKey([]byte("fedcba9876543210")))
+
+	req := httptest.NewRequest(http.MethodGet, "/a.txt", nil)
+	req.Header.Set(headerAcceptEncoding, "aes128gcm")
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+
+	// The content is served as is.
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, []string{"aes128gcm"}, rec.Header().Values(headerContentEncoding))
+	assert.Equal(t, []string{headerAcceptEncoding}, rec.Header().Values(headerVary))
+	assert.Equal(t, content, rec.Body.Bytes())
+}
+
+func TestEncryptResponseHandler_Status(t *testing.T) {
+	key := []byte("0123456789abcdef")
+
+	tests := []struct {
+		name     string
+		method   string
+		code     int
+		encoding string
+		body     bool
+	}{
+		{name: "empty", method: http.MethodGet, code: http.StatusOK, encoding: "aes128gcm", body: true},
+		{name: "error", method: http.MethodGet, code: http.StatusNotFound, encoding: "aes128gcm", body: true},
+		{name: "no content", method: http.MethodGet, code: http.StatusNoContent},
+		{name: "not modified", method: http.MethodGet, code: http.StatusNotModified},
+		{name: "head", method: http.MethodHead, code: http.StatusOK, encoding: "aes128gcm"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(tt.code)
+			})
+			handler := EncryptResponseHandler(next, nil, WithKey(key))
+
+			req := httptest.NewRequest(tt.method, "/", nil)
+			req.Header.Set(headerAcceptEncoding, "aes128gcm")
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			assert.Equal(t, tt.code, rec.Code)
+			assert.Equal(t, tt.encoding, rec.Header().Get(headerContentEncoding))
+			if tt.body {
+				result, err := Decrypt(rec.Body.Bytes(), WithKey(key))
+				assert.Nil(t, err)
+				assert.Empty(t, result)
+			} else {
+				assert.Equal(t, 0, rec.Body.Len())
+			}
+		})
+	}
+}
+
+func TestEncryptResponseHandler_Range(t *testing.T) {
+	key := []byte("0123456789abcdef")
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.ServeContent(w, r, "a.txt", time.Time{}, strings.NewReader("plaintext"))
+	})
+	handler := EncryptResponseHandler(next, nil, WithKey(key))
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set(headerAcceptEncoding, "aes128gcm")
+	req.Header.Set(headerRange, "bytes=0-1")
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "", rec.Header().Get(headerAcceptRanges))
+	result, err := Decrypt(rec.Body.Bytes(), WithKey(key))
+	assert.Nil(t, err)
+	assert.Equal(t, "plaintext", string(result))
+}
+
+func TestEncryptResponseHandler_Flush(t *testing.T) {
+	key := []byte("0123456789abcdef")
+	flushed := make(chan struct{})
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, "hello")
+		assert.Nil(t, http.NewResponseController(w).Flush())
+		<-flushed
+		_, _ = io.WriteString(w, " world")
+	})
+	server := httptest.NewServer(EncryptResponseHandler(next, nil, WithKey(key)))
+	defer server.Close()
+
+	req, err := http.NewRequest(http.MethodGet, server.URL, nil)
+	assert.Nil(t, err)
+	req.Header.Set(headerAcceptEncoding, "aes128gcm")
+	res, err := server.Client().Do(req)
+	assert.Nil(t, err)
+	defer res.Body.Close()
+
+	r, err := NewDecryptReader(res.Body, WithKey(key))
+	assert.Nil(t, err)
+	buf := make([]byte, 5)
+	_, err = io.ReadFull(r, buf)
+	assert.Nil(t, err)
+	assert.Equal(t, "hello", string(buf))
+	close(flushed)
+
+	rest, err := io.ReadAll(r)
+	assert.Nil(t, err)
+	assert.Equal(t, " world", string(rest))
+}
+
+func TestEncryptResponseHandler_Error(t *testing.T) {
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Error("unexpected call")
+	})
+	handler := EncryptResponseHandler(next, func(r *http.Request) ([]Option, error) {
+		return nil, errors.New("unknown client")
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set(headerAcceptEncoding, "aes128gcm")
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusInternalServerError, rec.Code)
+}
+
+func TestEncryptResponseHandler_InvalidOptions(t *testing.T) {
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, "hello")
+	})
+	for _, opt := range []Option{WithPadSize(-1), WithSalt(make([]byte, 16))} {
+		handler := EncryptResponseHandler(next, nil, opt)
+
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set(headerAcceptEncoding, "aes128gcm")
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		assert.Equal(t, http.StatusInternalServerError, rec.Code)
+
+		// Responses that are not encrypted are served.
+		req = httptest.NewRequest(http.MethodGet, "/", nil)
+		rec = httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Equal(t, "hello", rec.Body.String())
+	}
+}
+
+func TestEncryptResponseHandler_Encoding(t *testing.T) {
+	key := []byte("0123456789abcdef")
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, "hello")
+	})
+	handler := EncryptResponseHandler(next, func(r *http.Request) ([]Option, error) {
+		return []Option{WithEncoding(AESGCM)}, nil
+	}, WithEncoding(AESGCM), WithKey(key))
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set(headerAcceptEncoding, "aes128gcm")
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	assert.Equal(t, "aes128gcm", rec.Header().Get(headerContentEncoding))
+	plaintext, err := Decrypt(rec.Body.Bytes(), WithKey(key))
+	assert.Nil(t, err)
+	assert.Equal(t, "hello", string(plaintext))
+}
+
+func TestAcceptsCoding(t *testing.T) {
+	assert.True(t, acceptsCoding([]string{"gzip", "AES128GCM;q=0.5"}, "aes128gcm"))
+	assert.False(t, acceptsCoding([]string{"aes128gcm; q=0"}, "aes128gcm"))
+	assert.False(t, acceptsCoding([]string{"aes128gcm;q=x"}, "aes128gcm"))
+	assert.False(t, acceptsCoding([]string{"aesgcm"}, "aes128gcm"))
+	assert.True(t, acceptsCoding([]string{"*"}, "aes128gcm"))
+	assert.True(t, acceptsCoding([]string{"gzip;q=0", "*;q=0.1"}, "aes128gcm"))
+	assert.False(t, acceptsCoding([]string{"*;q=0"}, "aes128gcm"))
+	assert.False(t, acceptsCoding([]string{"aes128gcm;q=0, *"}, "aes128gcm"))
+	assert.False(t, acceptsCoding([]string{"*", "aes128gcm;q=0"}, "aes128gcm"))
+	assert.True(t, acceptsCoding([]string{"*;q=0, aes128gcm"}, "aes128gcm"))
+}
+
+func TestDecryptRequestHandler_Compressed(t *testing.T) {
+	key := []byte("0123456789abcdef")
+	handler := DecryptRequestHandler(echoHandler(t), WithKey(key))
+	plaintext := strings.Repeat("hello world ", 1000)
+
+	for _, coding := range []string{"gzip", "deflate"} {
+		var buf bytes.Buffer
+		w, err := encodeWriter(&buf, []string{coding, "aes128gcm"}, func(w io.Writer) (io.WriteCloser, error) {
+			return NewEncryptWriter(w, WithKey(key))
+		})
+		assert.Nil(t, err)
+		_, err = io.WriteString(w, plaintext)
+		assert.Nil(t, err)
+		assert.Nil(t, w.Close())
+
+		req := httptest.NewRequest(http.MethodPost, "/", &buf)
+		req.Header.Set(headerContentEncoding, coding+", aes128gcm")
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Equal(t, plaintext, rec.Body.String())
+		assert.Equal(t, "", rec.Header().Get("X-Content-Encoding"))
+	}
+}
+
+func TestEncryptResponseHandler_Compressed(t *testing.T) {
+	key := []byte("0123456789abcdef")
+	plaintext := strings.Repeat("hello world ", 1000)
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/deflated" {
+			// A coding applied by next is kept.
+			w.Header().Set(headerContentEncoding, "deflate")
+		}
+		_, _ = io.WriteString(w, plaintext)
+	})
+	handler := EncryptResponseHandler(next, nil, WithKey(key), WithCompression("gzip"))
+
+	tests := []struct {
+		path     string
+		accept   string
+		encoding string
+	}{
+		{path: "/", accept: "gzip, aes128gcm", encoding: "gzip, aes128gcm"},
+		{path: "/", accept: "aes128gcm", encoding: "aes128gcm"},
+		{path: "/deflated", accept: "gzip, aes128gcm", encoding: "deflate, aes128gcm"},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+		req.Header.Set(headerAcceptEncoding, tt.accept)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+
+		codings := parseCodings(rec.Header().Values(headerContentEncoding))
+		assert.Equal(t, tt.encoding, strings.Join(codings, ", "))
+		if tt.path == "/deflated" {
+			continue
+		}
+		r, err := decodeReader(rec.Body, codings, func(r io.Reader) (io.Reader, error) {
+			return NewDecryptReader(r, WithKey(key))
+		})
+		assert.Nil(t, err)
+		result, err := io.ReadAll(r)
+		assert.Nil(t, err)
+		assert.Equal(t, plaintext, string(result))
+	}
+}
@@ -0,0 +1,189 @@
+/*
+ * Copyright (c) 2019 Zenichi Amano
+ *
+ * This file is part of http-ece, which is MIT licensed.
+ * See http://opensource.org/licenses/MIT
+ */
+
+package httpece
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+)
+
+const (
+	headerLenMin  = keyLen + recodeSizeLen + 1
+	recordSizeMin = tagLen + 2 // tag, delimiter and at least one data byte
+)
+
+// Header is the header of aes128gcm content.
+type Header struct {
+	Salt       []byte // Encryption salt
+	RecordSize uint32 // Record Size
+	KeyID      []byte // key Identifier
+}
+
+// HeaderError reports a malformed aes128gcm header.
+type HeaderError struct {
+	Field string // Malformed field: "salt", "rs", "idlen" or "keyid"
+	Err   error  // Cause, ErrTruncated when the content ends inside the header
+}
+
+func (e *HeaderError) Error() string {
+	return fmt.Sprintf("invalid header %s: %v", e.Field, e.Err)
+}
+
+func (e *HeaderError) Unwrap() error {
+	return e.Err
+}
+
+// ParseHeader parses the aes128gcm header at the start of b, and returns the header and the records that follow.
+// Salt and KeyID refer to b.
+func ParseHeader(b []byte) (Header, []byte, error) {
+	n := len(b)
+	switch {
+	case n < keyLen:
+		return Header{}, nil, &HeaderError{Field: "salt", Err: ErrTruncated}
+	case n < keyLen+recodeSizeLen:
+		return Header{}, nil, &HeaderError{Field: "rs", Err: ErrTruncated}
+	case n < headerLenMin:
+		return Header{}, nil, &HeaderError{Field: "idlen", Err: ErrTruncated}
+	case n < headerLength(b):
+		return Header{}, nil, &HeaderError{Field: "keyid", Err: ErrTruncated}
+	}
+
+	h := Header{
+		Salt:       b[:keyLen],
+		RecordSize: binary.BigEndian.Uint32(b[keyLen:]),
+		KeyID:      b[headerLenMin:headerLength(b)],
+	}
+	if h.RecordSize < recordSizeMin || h.RecordSize > recordSizeMax {
+		return Header{}, nil, &HeaderError{Field: "rs", Err: fmt.Errorf("record size %d out of range", h.RecordSize)}
+	}
+	return h, b[headerLength(b):], nil
+}
+
+// Len returns the size of the encoded header.
+func (h Header) Len() int {
+	return headerLenMin + len(h.KeyID)
+}
+
+// AppendBinary appends the encoded header to b.
+func (h Header) AppendBinary(b []byte) ([]byte, error) {
+	keyIDLen := len(h.KeyID)
+	if keyIDLen > math.MaxUint8 {
+		return b, fmt.Errorf("invalid keyID length %d", keyIDLen)
+	}
+	saltLen := len(h.Salt)
+	if saltLen != keyLen {
+		return b, fmt.Errorf("invalid salt length %d", saltLen)
+	}
+	b = append(b, h.Salt...)
+	b = binary.BigEndian.AppendUint32(b, h.RecordSize)
+	b = append(b, uint8(keyIDLen))
+	return append(b, h.KeyID...), nil
+}
+
+// MarshalBinary encodes the header.
+func (h Header) MarshalBinary() ([]byte, error) {
+	return h.AppendBinary(make([]byte, 0, h.Len()))
+}
+
+// UnmarshalBinary decodes a header that makes up all of data.
+func (h *Header) UnmarshalBinary(data []byte) error {
+	parsed, rest, err := ParseHeader(data)
+	if err != nil {
+		return err
+	}
+	if len(rest) > 0 {
+		return &HeaderError{Field: "keyid", Err: fmt.Errorf("%d bytes after header", len(rest))}
+	}
+	h.Salt = bytes.Clone(parsed.Salt)
+	h.RecordSize = parsed.RecordSize
+	h.KeyID = bytes.Clone(parsed.KeyID)
+	return nil
+}
+
+// WriteTo writes the encoded header to w.
+func (h Header) WriteTo(w io.Writer) (int64, error) {
+	b, err := h.MarshalBinary()
+	if err != nil {
+		return 0, err
+	}
+	n, err := w.Write(b)
+	return int64(n), err
+}
+
+// apply sets the header fields to the options.
+func (h Header) apply(opt *options) {
+	opt.salt = h.Salt
+	opt.recordSize = h.RecordSize
+	opt.keyID = h.KeyID
+}
+
+// headerLength returns the header length known from the partial header b.
+func headerLength(b []byte) int {
+	n := headerLenMin
+	if len(b) >= n {
+		n += int(b[n-1])
+	}
+	return n
+}
+
+// headerValue returns the header for the options.
+func (o *options) headerValue() Header {
+	return Header{
+		Salt:       o.salt,
+		RecordSize: o.recordSize,
+		KeyID:      o.keyID,
+	}
+}
+
+// readHeader reads the header from content, and returns the records that follow.
+func readHeader(opt *options, content []byte) ([]byte, error) {
+	if opt.header != nil {
+		opt.header.apply(opt)
+		return content, nil
+	}
+	if opt.encoding != AES128GCM {
+		return content, nil
+	}
+
+	h, records, err := ParseHeader(content)
+	if err != nil {
+		return nil, err
+	}
+	if opt.maxRecordSize > 0 && h.RecordSize > opt.maxRecordSize {
+		return nil, &HeaderError{Field: "rs", Err: fmt.Errorf("record size %d exceeds %d", h.RecordSize, opt.maxRecordSize)}
+	}
+	h.apply(opt)
+	return records, nil
+}
+
+// readHeaderFrom reads the header from r.
+func readHeaderFrom(opt *options, r io.Reader) error {
+	if opt.header != nil || opt.encoding != AES128GCM {
+		_, err := readHeader(opt, nil)
+		return err
+	}
+
+	b := make([]byte, headerLenMin, headerLenMin+keyIDLenMax)
+	n, err := io.ReadFull(r, b)
+	if err == nil {
+		b = b[:headerLength(b)]
+		var m int
+		m, err = io.ReadFull(r, b[headerLenMin:])
+		n += m
+	}
+	if err != nil && !errors.Is(err, io.EOF) && !errors.Is(err, io.ErrUnexpectedEOF) {
+		return err
+	}
+
+	_, err = readHeader(opt, b[:n])
+	return err
+}
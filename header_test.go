@@ -0,0 +1,158 @@
+/*
+ * Copyright (c) 2019 Zenichi Amano
+ *
+ * This file is part of http-ece, which is MIT licensed.
+ * See http://opensource.org/licenses/MIT
+ */
+
+package httpece
+
+import (
+	"bytes"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseHeader(t *testing.T) {
+	content := d(t, "DGv6ra1nlYgDCS1FRnbzlwAAEABBBP4z9KsN6nGRTbVYI_c7VJSPQTBtkgcy27mlmlMoZIIgDll6e3vCYLocInmYWAmS6TlzAC8wEqKK6PBru3jl7A_yl95bQpu6cVPTpK4Mqgkf1CXztLVBSt2Ks3oZwbuwXPXLWyouBWLVWGNWQexSgSxsj_Qulcy4a-fN")
+
+	h, records, err := ParseHeader(content)
+	assert.Nil(t, err)
+	assert.Equal(t, d(t, "DGv6ra1nlYgDCS1FRnbzlw"), h.Salt)
+	assert.Equal(t, uint32(4096), h.RecordSize)
+	assert.Equal(t, d(t, "BP4z9KsN6nGRTbVYI_c7VJSPQTBtkgcy27mlmlMoZIIgDll6e3vCYLocInmYWAmS6TlzAC8wEqKK6PBru3jl7A8"), h.KeyID)
+	assert.Equal(t, content[86:], records)
+}
+
+func TestParseHeader_Error(t *testing.T) {
+	f := func(b []byte, field string) {
+		_, records, err := ParseHeader(b)
+		assert.Nil(t, records)
+		var headerErr *HeaderError
+		if assert.ErrorAs(t, err, &headerErr) {
+			assert.Equal(t, field, headerErr.Field)
+		}
+	}
+
+	header := []byte("0123456789abcdef\x00\x00\x10\x00\x02a1")
+	f(nil, "salt")
+	f(header[:10], "salt")
+	f(header[:17], "rs")
+	f(header[:20], "idlen")
+	f(header[:21], "keyid")
+	f(header[:22], "keyid")
+	f([]byte("0123456789abcdef\x00\x00\x00\x11\x00"), "rs")
+	f([]byte("0123456789abcdef\x80\x00\x00\x00\x00"), "rs")
+
+	_, _, err := ParseHeader(header[:22])
+	assert.ErrorIs(t, err, ErrTruncated)
+	assert.EqualError(t, err, "invalid header keyid: content truncated")
+}
+
+func TestDecrypt_MalformedHeader(t *testing.T) {
+	plaintext, err := Decrypt([]byte("0123456789abcdef\x00\x00\x10\x00\xffa1"), WithKey([]byte("0123456789abcdef")))
+	assert.ErrorIs(t, err, ErrTruncated)
+	assert.Nil(t, plaintext)
+
+	_, err = NewDecryptReader(bytes.NewReader([]byte("0123456789abcdef\x00\x00\x00\x01\x00")), WithKey([]byte("0123456789abcdef")))
+	var headerErr *HeaderError
+	assert.ErrorAs(t, err, &headerErr)
+}
+
+func FuzzDecrypt(f *testing.F) {
+	key := []byte("0123456789abcdef")
+	content, err := Encrypt([]byte("hello world"), WithKey(key), WithKeyID([]byte("a1")), WithRecordSize(18))
+	assert.Nil(f, err)
+	f.Add(content)
+	f.Add(content[:20])
+	f.Add([]byte("0123456789abcdef\x00\x00\x10\x00\xffa1"))
+
+	f.Fuzz(func(t *testing.T, content []byte) {
+		for _, encoding := range []ContentEncoding{AES128GCM, AESGCM} {
+			opts := []Option{WithEncoding(encoding), WithKey(key), WithSalt(key), WithRecordSize(18)}
+			_, _ = Decrypt(content, opts...)
+			if r, err := NewDecryptReader(bytes.NewReader(content), opts...); err == nil {
+				_, _ = io.ReadAll(r)
+			}
+			w := NewDecryptWriter(io.Discard, opts...)
+			_, _ = w.Write(content)
+			_ = w.Close()
+		}
+	})
+}
+
+func TestHeader_MarshalBinary(t *testing.T) {
+	h := Header{
+		Salt:       []byte("0123456789abcdef"),
+		RecordSize: 4096,
+		KeyID:      []byte("a1"),
+	}
+	b, err := h.MarshalBinary()
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("0123456789abcdef\x00\x00\x10\x00\x02a1"), b)
+	assert.Equal(t, len(b), h.Len())
+
+	var parsed Header
+	assert.Nil(t, parsed.UnmarshalBinary(b))
+	assert.Equal(t, h, parsed)
+
+	var buf bytes.Buffer
+	n, err := h.WriteTo(&buf)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(len(b)), n)
+	assert.Equal(t, b, buf.Bytes())
+
+	assert.NotNil(t, parsed.UnmarshalBinary(append(b, 0)))
+
+	_, err = Header{Salt: []byte("short")}.MarshalBinary()
+	assert.EqualError(t, err, "invalid salt length 5")
+	_, err = Header{Salt: h.Salt, KeyID: make([]byte, 256)}.MarshalBinary()
+	assert.EqualError(t, err, "invalid keyID length 256")
+}
+
+func TestWithHeader(t *testing.T) {
+	key := []byte("0123456789abcdef")
+	h := Header{
+		Salt:       []byte("fedcba9876543210"),
+		RecordSize: 100,
+		KeyID:      []byte("a1"),
+	}
+	plaintext := bytes.Repeat([]byte("a"), 1000)
+
+	records, err := Encrypt(plaintext, WithKey(key), WithHeader(h))
+	assert.Nil(t, err)
+
+	// The header and the records make up the usual content.
+	content, err := Encrypt(plaintext, WithKey(key), WithSalt(h.Salt), WithRecordSize(100), WithKeyID(h.KeyID))
+	assert.Nil(t, err)
+	header, err := h.MarshalBinary()
+	assert.Nil(t, err)
+	assert.Equal(t, content, append(header, records...))
+
+	result, err := Decrypt(records, WithKey(key), WithHeader(h))
+	assert.Nil(t, err)
+	assert.Equal(t, plaintext, result)
+
+	var buf bytes.Buffer
+	w, err := NewEncryptWriter(&buf, WithKey(key), WithHeader(h))
+	assert.Nil(t, err)
+	_, err = w.Write(plaintext)
+	assert.Nil(t, err)
+	assert.Nil(t, w.Close())
+	assert.Equal(t, records, buf.Bytes())
+
+	r, err := NewDecryptReader(bytes.NewReader(records), WithKey(key), WithHeader(h))
+	assert.Nil(t, err)
+	result, err = io.ReadAll(r)
+	assert.Nil(t, err)
+	assert.Equal(t, plaintext, result)
+
+	buf.Reset()
+	dw := NewDecryptWriter(&buf, WithKey(key), WithHeader(h))
+	_, err = dw.Write(records)
+	assert.Nil(t, err)
+	assert.Nil(t, dw.Close())
+	assert.Equal(t, plaintext, buf.Bytes())
+}
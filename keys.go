@@ -20,17 +20,46 @@ import (
 type key []byte
 type nonce []byte
 
-func deriveKeyAndNonce(opt *options) (key, nonce, error) {
-	var secret, context []byte
-	var keyInfo, nonceInfo string
-	var err error
+// createCipherAndNonce derives the content encryption key and base nonce, and creates the cipher.
+// The result is cached when the options carry a cipher cache.
+func createCipherAndNonce(opt *options) (cipher.AEAD, nonce, error) {
+	secret, keyInfo, nonceInfo, err := extractKeyMaterial(opt)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var id string
+	if opt.cipherCache != nil {
+		id = cacheKey(secret, opt.salt, []byte(keyInfo), []byte(nonceInfo))
+		if c, ok := opt.cipherCache.get(id); ok {
+			return c.gcm, c.baseNonce, nil
+		}
+	}
+
+	key, baseNonce, err := expandKeyAndNonce(secret, opt.salt, keyInfo, nonceInfo)
+	if err != nil {
+		return nil, nil, err
+	}
+	gcm, err := createCipher(key)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if opt.cipherCache != nil {
+		opt.cipherCache.add(id, cachedCipher{gcm: gcm, baseNonce: baseNonce})
+	}
+	return gcm, baseNonce, nil
+}
+
+func extractKeyMaterial(opt *options) (secret []byte, keyInfo, nonceInfo string, err error) {
+	var context []byte
 
 	switch opt.encoding {
 	case AESGCM:
 		// old
 		secret, context, err = extractSecretAndContext(opt)
 		if err != nil {
-			return nil, nil, err
+			return nil, "", "", err
 		}
 		keyInfo = buildInfo(aesgcmInfo, context)
 		nonceInfo = buildInfo(nonceBaseInfo, context)
@@ -38,20 +67,23 @@ func deriveKeyAndNonce(opt *options) (key, nonce, error) {
 		// latest
 		secret, err = extractSecret(opt)
 		if err != nil {
-			return nil, nil, err
+			return nil, "", "", err
 		}
 		keyInfo = buildInfo(aes128gcmInfo, nil)
 		nonceInfo = buildInfo(nonceBaseInfo, nil)
 	default:
-		return nil, nil, fmt.Errorf("must include a Salt parameter for %s", opt.encoding)
+		return nil, "", "", fmt.Errorf("must include a Salt parameter for %s", opt.encoding)
 	}
+	return secret, keyInfo, nonceInfo, nil
+}
 
+func expandKeyAndNonce(secret, salt []byte, keyInfo, nonceInfo string) (key, nonce, error) {
 	debug.dumpInfo("info aesgcm", keyInfo)
 	debug.dumpInfo("info nonce", nonceInfo)
 	debug.dumpBinary("hkdf secret", secret)
-	debug.dumpBinary("hkdf salt", opt.salt)
+	debug.dumpBinary("hkdf salt", salt)
 
-	prk, err := hkdf.Extract(hashAlgorithm, secret, opt.salt)
+	prk, err := hkdf.Extract(hashAlgorithm, secret, salt)
 	if err != nil {
 		return nil, nil, err
 	}
@@ -132,13 +164,13 @@ func extractSecret(opt *options) ([]byte, error) {
 		}
 		return opt.key, nil
 	}
-	if opt.privateKey == nil {
-		key := opt.keyMap(opt.keyID)
-		if key == nil {
-			return nil, fmt.Errorf("no saved key (keyID: \"%s\")", opt.keyID)
-		}
+	if key := opt.keyMap(opt.keyID); key != nil {
 		return key, nil
 	}
+	if opt.ephemeral && opt.dh == nil {
+		// Neither a private key nor a remote public key to agree on a secret with.
+		return nil, fmt.Errorf("%w: no saved key (keyID: %q)", ErrUnableDetermineKey, opt.keyID)
+	}
 	if opt.authSecret == nil {
 		return nil, ErrNoAuthSecret
 	}
@@ -237,9 +269,24 @@ func randomKey() (*ecdh.PrivateKey, error) {
 }
 
 func (o *options) getSecret(publicKey []byte) (secret []byte, err error) {
+	var id string
+	if o.secretCache != nil {
+		id = cacheKey(o.publicKey.Bytes(), publicKey)
+		if secret, ok := o.secretCache.get(id); ok {
+			return secret, nil
+		}
+	}
+
 	var dh *ecdh.PublicKey
 	if dh, err = curve.NewPublicKey(publicKey); err != nil {
 		return nil, err
 	}
-	return o.privateKey.ECDH(dh)
+	if secret, err = o.privateKey.ECDH(dh); err != nil {
+		return nil, err
+	}
+
+	if o.secretCache != nil {
+		o.secretCache.add(id, secret)
+	}
+	return secret, nil
 }
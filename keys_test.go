@@ -8,6 +8,7 @@
 package httpece
 
 import (
+	"errors"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -29,3 +30,25 @@ func TestRandomKey(t *testing.T) {
 	assert.NotEqual(t, private, private2)
 	assert.NotEqual(t, public, public2)
 }
+
+func TestExtractSecret_UnknownKey(t *testing.T) {
+	content, err := Encrypt([]byte("test"), WithKey([]byte("0123456789abcdef")), WithKeyID([]byte("a1")))
+	assert.Nil(t, err)
+
+	keyMap := func(keyID []byte) []byte {
+		if string(keyID) == "a1" {
+			return []byte("0123456789abcdef")
+		}
+		return nil
+	}
+	plaintext, err := Decrypt(content, WithKeyMap(keyMap))
+	assert.Nil(t, err)
+	assert.Equal(t, "test", string(plaintext))
+
+	_, err = Decrypt(content, WithKeyMap(func([]byte) []byte { return nil }))
+	assert.True(t, errors.Is(err, ErrUnableDetermineKey), err)
+	assert.EqualError(t, err, `unable to determine key: no saved key (keyID: "a1")`)
+
+	_, err = Encrypt([]byte("test"), WithDh(make([]byte, 65)))
+	assert.True(t, errors.Is(err, ErrNoAuthSecret), err)
+}
@@ -0,0 +1,87 @@
+/*
+ * Copyright (c) 2019 Zenichi Amano
+ *
+ * This file is part of http-ece, which is MIT licensed.
+ * See http://opensource.org/licenses/MIT
+ */
+
+package httpece
+
+import (
+	"fmt"
+)
+
+// EncryptedLen returns the size of the content that Encrypt produces for plaintextLen bytes of plaintext.
+// The header is included unless it is detached with WithHeader.
+func EncryptedLen(plaintextLen int, opts ...Option) (int, error) {
+	var opt *options
+	var err error
+
+	// Options
+	if opt, err = parseOptions(encrypt, opts); err != nil {
+		return 0, err
+	}
+	return encryptedLen(opt, plaintextLen)
+}
+
+func encryptedLen(opt *options, plaintextLen int) (int, error) {
+	if plaintextLen < 0 {
+		return 0, fmt.Errorf("invalid plaintext length %d", plaintextLen)
+	}
+	prepareHeader(opt)
+
+	// Check Record Size
+	overhead := opt.encoding.overhead()
+	recordSize := int(opt.recordSize)
+	if recordSize <= overhead {
+		return 0, fmt.Errorf("recordSize has to be greater than %d", overhead)
+	}
+
+	state := &encryptState{
+		opt:            opt,
+		baseRecordSize: recordSize - overhead,
+		padSize:        opt.padSize,
+	}
+	return headerSize(opt) + state.sealedLen(plaintextLen), nil
+}
+
+// MaxDecryptedLen returns the largest plaintext that content of ciphertextLen bytes can hold.
+// The content is assumed to use the record size and key ID of the options;
+// the result is exact when the content is not padded.
+func MaxDecryptedLen(ciphertextLen int, opts ...Option) (int, error) {
+	var opt *options
+	var err error
+
+	// Options
+	if opt, err = parseOptions(decrypt, opts); err != nil {
+		return 0, err
+	}
+	if ciphertextLen < 0 {
+		return 0, fmt.Errorf("invalid ciphertext length %d", ciphertextLen)
+	}
+
+	contentLen := ciphertextLen
+	if opt.header != nil {
+		opt.header.apply(opt)
+	} else if opt.encoding == AES128GCM {
+		contentLen -= headerLenMin + len(opt.keyID)
+	}
+
+	// Check Record Size
+	overhead := opt.encoding.overhead()
+	recordSize := int(opt.recordSize)
+	if recordSize < overhead {
+		return 0, fmt.Errorf("recordSize has to be greater than %d", overhead)
+	}
+
+	blockSize := recordSize
+	if opt.encoding != AES128GCM {
+		blockSize += tagLen
+	}
+	if contentLen <= 0 {
+		return 0, nil
+	}
+
+	records := (contentLen + blockSize - 1) / blockSize
+	return max(contentLen-records*(opt.encoding.Padding()+tagLen), 0), nil
+}
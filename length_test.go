@@ -0,0 +1,100 @@
+/*
+ * Copyright (c) 2019 Zenichi Amano
+ *
+ * This file is part of http-ece, which is MIT licensed.
+ * See http://opensource.org/licenses/MIT
+ */
+
+package httpece
+
+import (
+	"testing"
+	"testing/quick"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEncryptedLen(t *testing.T) {
+	key := []byte("0123456789abcdef")
+	salt := d(t, "mRGYnIzSJGeZnJ19lgQcfw==")
+
+	for _, encoding := range []ContentEncoding{AES128GCM, AESGCM} {
+		t.Run(string(encoding), func(t *testing.T) {
+			property := func(size uint16, recordSize uint8, padSize uint16, keyID bool) bool {
+				size %= 10000
+				padSize %= 10000
+				opts := []Option{
+					WithEncoding(encoding),
+					WithKey(key),
+					WithSalt(salt),
+					WithRecordSize(int(recordSize) + 19),
+					WithPadSize(int(padSize)),
+				}
+				if keyID {
+					opts = append(opts, WithKeyID([]byte("a1")))
+				}
+
+				content, err := Encrypt(make([]byte, size), opts...)
+				if !assert.Nil(t, err) {
+					return false
+				}
+				n, err := EncryptedLen(int(size), opts...)
+				if !assert.Nil(t, err) {
+					return false
+				}
+				if !assert.Equal(t, len(content), n) {
+					return false
+				}
+
+				if !keyID && encoding == AES128GCM {
+					// The key ID of the content is the sender public key.
+					header, _, err := ParseHeader(content)
+					if !assert.Nil(t, err) {
+						return false
+					}
+					opts = append(opts, WithKeyID(header.KeyID))
+				}
+				m, err := MaxDecryptedLen(len(content), opts...)
+				if !assert.Nil(t, err) {
+					return false
+				}
+				if padSize == 0 {
+					return assert.Equal(t, int(size), m)
+				}
+				return assert.GreaterOrEqual(t, m, int(size))
+			}
+			assert.Nil(t, quick.Check(property, &quick.Config{MaxCount: 500}))
+		})
+	}
+}
+
+func TestEncryptedLen_Header(t *testing.T) {
+	header := Header{
+		Salt:       d(t, "mRGYnIzSJGeZnJ19lgQcfw=="),
+		RecordSize: 100,
+		KeyID:      []byte("a1"),
+	}
+	key := []byte("0123456789abcdef")
+
+	content, err := Encrypt(make([]byte, 1000), WithKey(key), WithHeader(header))
+	assert.Nil(t, err)
+	n, err := EncryptedLen(1000, WithKey(key), WithHeader(header))
+	assert.Nil(t, err)
+	assert.Equal(t, len(content), n)
+	m, err := MaxDecryptedLen(len(content), WithHeader(header))
+	assert.Nil(t, err)
+	assert.Equal(t, 1000, m)
+}
+
+func TestEncryptedLen_Invalid(t *testing.T) {
+	_, err := EncryptedLen(-1)
+	assert.NotNil(t, err)
+	_, err = EncryptedLen(10, WithRecordSize(17))
+	assert.NotNil(t, err)
+	_, err = MaxDecryptedLen(-1)
+	assert.NotNil(t, err)
+
+	m, err := MaxDecryptedLen(10)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, m)
+}
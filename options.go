@@ -9,25 +9,40 @@ package httpece
 
 import (
 	"crypto/ecdh"
+	"errors"
 	"fmt"
 )
 
 type KeyMappingFn func([]byte) []byte
 
 type options struct {
-	mode       mode             // Encrypt / Decrypt Mode
-	encoding   ContentEncoding  // Content Encoding
-	recordSize uint32           // Record Size
-	salt       []byte           // Encryption salt
-	key        []byte           // Encryption key data
-	padSize    int              // Record padding size
-	authSecret []byte           // Auth Secret
-	keyID      []byte           // key Identifier
-	keyLabel   []byte           // Key Label
-	keyMap     KeyMappingFn     // Key Mapping Function
-	privateKey *ecdh.PrivateKey // DH Private key
-	publicKey  *ecdh.PublicKey  // DH Public key
-	dh         []byte           // Remote Diffie Hellman sequence
+	mode          mode             // Encrypt / Decrypt Mode
+	encoding      ContentEncoding  // Content Encoding
+	recordSize    uint32           // Record Size
+	maxRecordSize uint32           // Largest record size accepted from a header, 0 for any
+	salt          []byte           // Encryption salt
+	key           []byte           // Encryption key data
+	padSize       int              // Record padding size
+	authSecret    []byte           // Auth Secret
+	keyID         []byte           // key Identifier
+	keyLabel      []byte           // Key Label
+	keyMap        KeyMappingFn     // Key Mapping Function
+	privateKey    *ecdh.PrivateKey // DH Private key
+	publicKey     *ecdh.PublicKey  // DH Public key
+	dh            []byte           // Remote Diffie Hellman sequence
+	ephemeral     bool             // Private key has been generated
+	cacheSize     int              // Maximum number of cached keys
+	unpadded      bool             // Non-last records carry no padding
+	workers       int              // Number of goroutines sealing records
+	header        *Header          // Header kept apart from the content
+	cryptoHeaders *CryptoHeaders   // Destination of the Encryption and Crypto-Key headers
+	compression   string           // Content coding applied before encryption over HTTP
+	digest        *Digest          // Destination of the digest of the ciphertext
+	verifyDigest  string           // Expected digest of the ciphertext
+	digestAlg     DigestAlgorithm  // Digest sent with encrypted bodies over HTTP
+
+	secretCache *lruCache[[]byte]       // ECDH shared secrets
+	cipherCache *lruCache[cachedCipher] // Derived ciphers
 }
 
 func (o *options) initialize() error {
@@ -39,11 +54,24 @@ func (o *options) initialize() error {
 			return err
 		}
 		o.privateKey = privateKey
+		o.ephemeral = true
 	}
 	o.publicKey = o.privateKey.PublicKey()
 	return nil
 }
 
+// checkShared rejects the options that receive results of a single message,
+// which Encryptor and Decryptor take only per message.
+func (o *options) checkShared() error {
+	if o.cryptoHeaders != nil {
+		return errors.New("WithCryptoHeaders has to be given per message")
+	}
+	if o.digest != nil {
+		return errors.New("WithDigest has to be given per message")
+	}
+	return nil
+}
+
 type Option func(*options) error
 
 func WithEncoding(value ContentEncoding) Option {
@@ -73,6 +101,7 @@ func WithPadSize(value int) Option {
 func WithPrivate(value []byte) Option {
 	return func(opts *options) (err error) {
 		opts.privateKey, err = curve.NewPrivateKey(value)
+		opts.ephemeral = false
 		return err
 	}
 }
@@ -101,6 +130,19 @@ func WithRecordSize(value int) Option {
 	}
 }
 
+// WithMaxRecordSize limits the record size that decryption accepts from the header of the content.
+// A record is held in memory until it is authenticated, so content from untrusted senders should be limited.
+// Zero accepts any record size.
+func WithMaxRecordSize(value int) Option {
+	return func(opts *options) error {
+		if value < 0 || value > recordSizeMax {
+			return fmt.Errorf("invalid maximum record size %d: must be between 0 and %d", value, recordSizeMax)
+		}
+		opts.maxRecordSize = uint32(value)
+		return nil
+	}
+}
+
 func WithKey(value []byte) Option {
 	return func(opts *options) error {
 		opts.key = value
@@ -131,3 +173,65 @@ func WithKeyMap(value KeyMappingFn) Option {
 		return nil
 	}
 }
+
+// WithCacheSize sets the maximum number of derived keys an Encryptor or Decryptor keeps.
+// Zero disables caching.
+func WithCacheSize(value int) Option {
+	return func(opts *options) error {
+		if value < 0 {
+			return fmt.Errorf("invalid cache size %d: must be non-negative", value)
+		}
+		opts.cacheSize = value
+		return nil
+	}
+}
+
+// WithUnpaddedRecords declares that every record but the last carries a full record of plaintext,
+// as Encrypt produces without WithPadSize and encrypting writers produce without Flush.
+// NewDecryptReaderAt then maps offsets to records without decrypting the records in front.
+// Only the records that are read are checked, with ErrUnexpectedPadding: if a record in front of them is padded,
+// the plaintext at an offset and the size are wrong without an error.
+func WithUnpaddedRecords(value bool) Option {
+	return func(opts *options) error {
+		opts.unpadded = value
+		return nil
+	}
+}
+
+// WithConcurrency sets the number of goroutines that the one-shot functions and methods
+// (Encrypt, EncryptAppend, Decrypt, DecryptAppend) use to process records.
+// The result is the same as with a single goroutine. Values below 2 process records serially.
+func WithConcurrency(value int) Option {
+	return func(opts *options) error {
+		if value < 0 {
+			return fmt.Errorf("invalid concurrency %d: must be non-negative", value)
+		}
+		opts.workers = value
+		return nil
+	}
+}
+
+// WithHeader sets the salt, record size and key identifier from a header that is kept apart from the content.
+// Encryption then writes only the records, and decryption reads only the records.
+// Prepending the encoded header to the records gives the usual content.
+func WithHeader(value Header) Option {
+	return func(opts *options) error {
+		opts.header = &value
+		return nil
+	}
+}
+
+// WithCompression sets the content coding, "gzip" or "deflate", that the HTTP integration applies before encryption:
+// Transport compresses request bodies, and EncryptResponseHandler compresses responses for clients that accept it.
+// An empty value disables compression.
+func WithCompression(value string) Option {
+	return func(opts *options) error {
+		switch value {
+		case "", codingGzip, codingDeflate:
+		default:
+			return fmt.Errorf("%w: %s", ErrUnsupportedCoding, value)
+		}
+		opts.compression = value
+		return nil
+	}
+}
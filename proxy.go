@@ -0,0 +1,117 @@
+/*
+ * Copyright (c) 2019 Zenichi Amano
+ *
+ * This file is part of http-ece, which is MIT licensed.
+ * See http://opensource.org/licenses/MIT
+ */
+
+package httpece
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httputil"
+	"slices"
+	"strings"
+)
+
+// clientAcceptsKey is the context key of whether the client of a proxied request accepts aes128gcm.
+type clientAcceptsKey struct{}
+
+// EncryptProxyRequest returns a function to call from httputil.ReverseProxy.Rewrite after SetURL
+// that encrypts request bodies and asks the upstream for aes128gcm responses.
+func EncryptProxyRequest(opts ...Option) func(*httputil.ProxyRequest) {
+	enc, encErr := newEncryptor(pinEncoding(opts))
+
+	return func(pr *httputil.ProxyRequest) {
+		accepts := acceptsCoding(pr.In.Header.Values(headerAcceptEncoding), string(AES128GCM))
+		pr.Out = pr.Out.WithContext(context.WithValue(pr.Out.Context(), clientAcceptsKey{}, accepts))
+
+		if !accepts {
+			pr.Out.Header.Del(headerRange)
+			pr.Out.Header.Del(headerIfRange)
+		}
+		if !acceptsCoding(pr.Out.Header.Values(headerAcceptEncoding), string(AES128GCM)) {
+			pr.Out.Header.Add(headerAcceptEncoding, string(AES128GCM))
+		}
+
+		codings := parseCodings(pr.Out.Header.Values(headerContentEncoding))
+		if pr.Out.Body == nil || pr.Out.Body == http.NoBody || slices.Contains(codings, string(AES128GCM)) {
+			return
+		}
+
+		body := pr.Out.Body
+		err := encErr
+		var opt *options
+		if err == nil {
+			opt, err = enc.options(nil)
+		}
+		if err != nil {
+			pr.Out.Body = readCloser{&encryptReader{err: err}, body}
+			return
+		}
+		pr.Out.ContentLength = -1
+		if n := pr.In.ContentLength; n > 0 {
+			if m, err := encryptedLen(opt, int(n)); err == nil {
+				pr.Out.ContentLength = int64(m)
+			}
+		}
+		pr.Out.Body = readCloser{newEncryptReader(body, opt), body}
+		pr.Out.GetBody = nil
+		pr.Out.Header.Add(headerContentEncoding, string(AES128GCM))
+		pr.Out.Header.Del(headerContentLength)
+		delDigest(pr.Out.Header, pr.Out.Trailer)
+	}
+}
+
+// DecryptProxyResponse returns a function for httputil.ReverseProxy.ModifyResponse that decrypts
+// aes128gcm responses for clients that do not accept aes128gcm, as recorded by EncryptProxyRequest.
+func DecryptProxyResponse(opts ...Option) func(*http.Response) error {
+	dec, decErr := NewDecryptor(pinEncoding(opts)...)
+
+	return func(res *http.Response) error {
+		res.Header.Add(headerVary, headerAcceptEncoding)
+
+		codings := parseCodings(res.Header.Values(headerContentEncoding))
+		i := slices.Index(codings, string(AES128GCM))
+		// A response without a request is decrypted, as for a client that does not accept aes128gcm.
+		head := res.Request != nil && res.Request.Method == http.MethodHead
+		if i < 0 || clientAccepts(res.Request) || head || !bodyAllowed(res.StatusCode) {
+			return nil
+		}
+		if decErr != nil {
+			return decErr
+		}
+
+		body := verifyBody(res.Body, res.Header, res.Trailer, res.StatusCode == http.StatusPartialContent)
+		r, err := decodeReader(body, codings[i:], func(r io.Reader) (io.Reader, error) {
+			return dec.NewReader(r)
+		})
+		if err != nil {
+			return err
+		}
+		res.Body = readCloser{r, res.Body}
+		res.ContentLength = -1
+		res.Header.Del(headerContentEncoding)
+		if i > 0 {
+			res.Header.Set(headerContentEncoding, strings.Join(codings[:i], ", "))
+		}
+		res.Header.Del(headerContentLength)
+		res.Header.Del(headerAcceptRanges)
+		delDigest(res.Header, res.Trailer)
+		weakenETag(res.Header)
+		return nil
+	}
+}
+
+// clientAccepts reports whether the client of the proxied request accepts aes128gcm.
+func clientAccepts(req *http.Request) bool {
+	if req == nil {
+		return false
+	}
+	if accepts, ok := req.Context().Value(clientAcceptsKey{}).(bool); ok {
+		return accepts
+	}
+	return acceptsCoding(req.Header.Values(headerAcceptEncoding), string(AES128GCM))
+}
@@ -0,0 +1,230 @@
+/*
+ * Copyright (c) 2019 Zenichi Amano
+ *
+ * This file is part of http-ece, which is MIT licensed.
+ * See http://opensource.org/licenses/MIT
+ */
+
+package httpece
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/http/httputil"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newProxy(t *testing.T, upstream http.Handler, opts ...Option) *httptest.Server {
+	us := httptest.NewServer(upstream)
+	t.Cleanup(us.Close)
+	target, err := url.Parse(us.URL)
+	assert.Nil(t, err)
+
+	rewrite := EncryptProxyRequest(opts...)
+	proxy := &httputil.ReverseProxy{
+		Rewrite: func(pr *httputil.ProxyRequest) {
+			pr.SetURL(target)
+			rewrite(pr)
+		},
+		ModifyResponse: DecryptProxyResponse(opts...),
+	}
+	ps := httptest.NewServer(proxy)
+	t.Cleanup(ps.Close)
+	return ps
+}
+
+func TestReverseProxy(t *testing.T) {
+	key := []byte("0123456789abcdef")
+	upstream := DecryptRequestHandler(
+		EncryptResponseHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set(headerETag, `"v1"`)
+			w.Header().Set("X-Content-Encoding", r.Header.Get(headerContentEncoding))
+			_, _ = io.Copy(w, r.Body)
+		}), nil, WithKey(key), WithKeyID([]byte("k"))),
+		WithKey(key))
+	ps := newProxy(t, upstream, WithKey(key), WithKeyID([]byte("k")))
+	plaintext := strings.Repeat("I am the walrus", 500)
+
+	t.Run("legacy client", func(t *testing.T) {
+		req, err := http.NewRequest(http.MethodPost, ps.URL, strings.NewReader(plaintext))
+		assert.Nil(t, err)
+		req.Header.Set(headerAcceptEncoding, "identity")
+		res, err := http.DefaultTransport.RoundTrip(req)
+		assert.Nil(t, err)
+		defer res.Body.Close()
+
+		body, err := io.ReadAll(res.Body)
+		assert.Nil(t, err)
+		assert.Equal(t, http.StatusOK, res.StatusCode)
+		assert.Equal(t, plaintext, string(body))
+		assert.Equal(t, "", res.Header.Get(headerContentEncoding))
+		assert.Equal(t, `W/"v1"`, res.Header.Get(headerETag))
+		assert.Equal(t, headerAcceptEncoding, res.Header.Get(headerVary))
+		// Decrypted before the upstream handler saw it.
+		assert.Equal(t, "", res.Header.Get("X-Content-Encoding"))
+	})
+
+	t.Run("aes128gcm client", func(t *testing.T) {
+		req, err := http.NewRequest(http.MethodPost, ps.URL, strings.NewReader(plaintext))
+		assert.Nil(t, err)
+		req.Header.Set(headerAcceptEncoding, string(AES128GCM))
+		res, err := http.DefaultTransport.RoundTrip(req)
+		assert.Nil(t, err)
+		defer res.Body.Close()
+
+		assert.Equal(t, string(AES128GCM), res.Header.Get(headerContentEncoding))
+		body, err := io.ReadAll(res.Body)
+		assert.Nil(t, err)
+		plain, err := Decrypt(body, WithKey(key))
+		assert.Nil(t, err)
+		assert.Equal(t, plaintext, string(plain))
+	})
+}
+
+func TestEncryptProxyRequest(t *testing.T) {
+	key := []byte("0123456789abcdef")
+	var got *http.Request
+	var body []byte
+	ps := newProxy(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r
+		body, _ = io.ReadAll(r.Body)
+	}), WithKey(key))
+
+	t.Run("plaintext", func(t *testing.T) {
+		req, err := http.NewRequest(http.MethodPut, ps.URL, strings.NewReader("hello"))
+		assert.Nil(t, err)
+		req.Header.Set(headerRange, "bytes=0-1")
+		res, err := http.DefaultClient.Do(req)
+		assert.Nil(t, err)
+		_ = res.Body.Close()
+
+		assert.Equal(t, string(AES128GCM), got.Header.Get(headerContentEncoding))
+		n, err := EncryptedLen(len("hello"), WithKey(key))
+		assert.Nil(t, err)
+		assert.Equal(t, int64(n), got.ContentLength)
+		assert.Equal(t, "", got.Header.Get(headerRange))
+		assert.True(t, acceptsCoding(got.Header.Values(headerAcceptEncoding), string(AES128GCM)))
+		plain, err := Decrypt(body, WithKey(key))
+		assert.Nil(t, err)
+		assert.Equal(t, "hello", string(plain))
+	})
+
+	t.Run("encrypted", func(t *testing.T) {
+		ciphertext, err := Encrypt([]byte("hello"), WithKey(key))
+		assert.Nil(t, err)
+		req, err := http.NewRequest(http.MethodPut, ps.URL, bytes.NewReader(ciphertext))
+		assert.Nil(t, err)
+		req.Header.Set(headerContentEncoding, string(AES128GCM))
+		req.Header.Set(headerAcceptEncoding, string(AES128GCM))
+		req.Header.Set(headerRange, "bytes=0-1")
+		res, err := http.DefaultClient.Do(req)
+		assert.Nil(t, err)
+		_ = res.Body.Close()
+
+		assert.Equal(t, ciphertext, body)
+		assert.Equal(t, "bytes=0-1", got.Header.Get(headerRange))
+	})
+
+	t.Run("encoding", func(t *testing.T) {
+		ps := newProxy(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			body, _ = io.ReadAll(r.Body)
+		}), WithEncoding(AESGCM), WithKey(key))
+		res, err := http.Post(ps.URL, "text/plain", strings.NewReader("hello"))
+		assert.Nil(t, err)
+		_ = res.Body.Close()
+
+		plain, err := Decrypt(body, WithKey(key))
+		assert.Nil(t, err)
+		assert.Equal(t, "hello", string(plain))
+	})
+
+	t.Run("no body", func(t *testing.T) {
+		res, err := http.Get(ps.URL)
+		assert.Nil(t, err)
+		_ = res.Body.Close()
+
+		assert.Equal(t, "", got.Header.Get(headerContentEncoding))
+		assert.Empty(t, body)
+	})
+}
+
+func TestDecryptProxyResponse(t *testing.T) {
+	key := []byte("0123456789abcdef")
+	plaintext := []byte("I am the walrus")
+	ciphertext, err := Encrypt(plaintext, WithKey(key))
+	assert.Nil(t, err)
+
+	newResponse := func(codings string, accept string, body []byte) *http.Response {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set(headerAcceptEncoding, accept)
+		res := &http.Response{
+			StatusCode:    http.StatusOK,
+			Header:        http.Header{},
+			Body:          io.NopCloser(bytes.NewReader(body)),
+			ContentLength: int64(len(body)),
+			Request:       req,
+		}
+		res.Header.Set(headerContentEncoding, codings)
+		res.Header.Set(headerAcceptRanges, "bytes")
+		return res
+	}
+	modify := DecryptProxyResponse(WithKey(key))
+
+	t.Run("keeps inner codings", func(t *testing.T) {
+		res := newResponse("gzip, aes128gcm", "gzip", ciphertext)
+		assert.Nil(t, modify(res))
+		body, err := io.ReadAll(res.Body)
+		assert.Nil(t, err)
+		assert.Equal(t, plaintext, body)
+		assert.Equal(t, "gzip", res.Header.Get(headerContentEncoding))
+		assert.Equal(t, int64(-1), res.ContentLength)
+		assert.Equal(t, "", res.Header.Get(headerAcceptRanges))
+	})
+
+	t.Run("accepted", func(t *testing.T) {
+		res := newResponse("aes128gcm", "aes128gcm", ciphertext)
+		assert.Nil(t, modify(res))
+		assert.Equal(t, "aes128gcm", res.Header.Get(headerContentEncoding))
+		assert.Equal(t, int64(len(ciphertext)), res.ContentLength)
+	})
+
+	t.Run("no request", func(t *testing.T) {
+		res := newResponse("aes128gcm", "", ciphertext)
+		res.Request = nil
+		assert.Nil(t, modify(res))
+		body, err := io.ReadAll(res.Body)
+		assert.Nil(t, err)
+		assert.Equal(t, plaintext, body)
+	})
+
+	t.Run("wrong key", func(t *testing.T) {
+		res := newResponse("aes128gcm", "", ciphertext)
+		assert.Nil(t, DecryptProxyResponse(WithKey([]byte("fedcba9876543210")))(res))
+		_, err := io.ReadAll(res.Body)
+		assert.NotNil(t, err)
+	})
+
+	t.Run("invalid options", func(t *testing.T) {
+		res := newResponse("aes128gcm", "", ciphertext)
+		assert.NotNil(t, DecryptProxyResponse(WithPadSize(-1))(res))
+		// Responses that are not decrypted pass.
+		res = newResponse("", "", []byte("plaintext"))
+		assert.Nil(t, DecryptProxyResponse(WithPadSize(-1))(res))
+		res = newResponse("aes128gcm", "", ciphertext)
+		assert.NotNil(t, DecryptProxyResponse(WithRecordSize(1))(res))
+	})
+
+	t.Run("encoding", func(t *testing.T) {
+		res := newResponse("aes128gcm", "", ciphertext)
+		assert.Nil(t, DecryptProxyResponse(WithEncoding(AESGCM), WithKey(key))(res))
+		body, err := io.ReadAll(res.Body)
+		assert.Nil(t, err)
+		assert.Equal(t, plaintext, body)
+	})
+}
@@ -0,0 +1,205 @@
+/*
+ * Copyright (c) 2019 Zenichi Amano
+ *
+ * This file is part of http-ece, which is MIT licensed.
+ * See http://opensource.org/licenses/MIT
+ */
+
+package httpece
+
+import (
+	"cmp"
+	"io"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// RangeMode selects how ServeContent answers Range requests.
+type RangeMode int
+
+const (
+	// RangeCiphertext serves the content with Content-Encoding aes128gcm.
+	// Ranges are expanded to whole records, so that each part can be decrypted with the header of the content.
+	RangeCiphertext RangeMode = iota
+	// RangePlaintext decrypts the content and serves exactly the requested ranges of the plaintext.
+	// Since padding makes the plaintext of records vary, the records in front of the ranges are decrypted
+	// to locate them, see DecryptReaderAt. For content known to be free of padding,
+	// WithUnpaddedRecords(true) decrypts only the records that hold the ranges and the last one.
+	RangePlaintext
+)
+
+// ServeContent replies to r with the aes128gcm content of size bytes read from content, like http.ServeContent.
+// It handles Range requests, multiple ranges included, and the conditional headers of r;
+// the ETag set in w's header, which has to identify the representation of the mode, is used for If-Range.
+//
+// In RangePlaintext mode the content is decrypted with the options.
+// In RangeCiphertext mode the options only locate the records, e.g. WithHeader for content without a header.
+// The options are resolved on each call; servers answering many requests build a Decryptor once
+// and use Decryptor.ServeContent.
+func ServeContent(w http.ResponseWriter, r *http.Request, name string, modtime time.Time,
+	content io.ReaderAt, size int64, mode RangeMode, opts ...Option) {
+	dec, err := NewDecryptor(opts...)
+	if err != nil {
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	dec.ServeContent(w, r, name, modtime, content, size, mode)
+}
+
+// ServeContent is like the function ServeContent, using the options and caches of the Decryptor; opts override them.
+// The record sizes of padded content are kept per name, modtime and size unless modtime is zero.
+func (d *Decryptor) ServeContent(w http.ResponseWriter, r *http.Request, name string, modtime time.Time,
+	content io.ReaderAt, size int64, mode RangeMode, opts ...Option) {
+	opt, err := d.opt.clone(pinEncoding(opts))
+	if err != nil {
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	if mode == RangePlaintext {
+		dr, err := newDecryptReaderAt(content, size, opt)
+		if err != nil {
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+		// Check the key on the first record, before the headers are written.
+		if _, err = dr.record(0); err != nil {
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+		if d.index != nil && !opt.unpadded && !modtime.IsZero() {
+			key := name + "\x00" + strconv.FormatInt(modtime.UnixNano(), 10) + "\x00" + strconv.FormatInt(size, 10)
+			if ends, ok := d.index.get(key); ok {
+				dr.setIndex(ends)
+			}
+			defer func() {
+				if ends := dr.fullIndex(); ends != nil {
+					d.index.add(key, ends)
+				}
+			}()
+		}
+		http.ServeContent(w, r, name, modtime, dr)
+		return
+	}
+
+	sr := io.NewSectionReader(content, 0, size)
+	if err = readHeaderFrom(opt, sr); err != nil {
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	dataOffset, _ := sr.Seek(0, io.SeekCurrent)
+
+	if value := r.Header.Get(headerRange); value != "" {
+		if ranges, ok := parseRanges(value, size); ok {
+			r = r.Clone(r.Context())
+			r.Header.Set(headerRange, formatRanges(expandRanges(ranges, dataOffset, int64(opt.recordSize), size)))
+		}
+	}
+	w.Header().Set(headerContentEncoding, string(AES128GCM))
+	http.ServeContent(w, r, name, modtime, io.NewSectionReader(content, 0, size))
+}
+
+// byteRange is a range of content from start up to, but not including, end.
+type byteRange struct {
+	start, end int64
+}
+
+// parseRanges parses the Range header value s for content of size bytes.
+// Ranges that are not satisfiable are dropped, like http.ServeContent does.
+// It reports false for values that are invalid or have no satisfiable range, which http.ServeContent rejects itself.
+func parseRanges(s string, size int64) ([]byteRange, bool) {
+	spec, ok := strings.CutPrefix(s, "bytes=")
+	if !ok {
+		return nil, false
+	}
+
+	var ranges []byteRange
+	for part := range strings.SplitSeq(spec, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		first, last, ok := strings.Cut(part, "-")
+		if !ok {
+			return nil, false
+		}
+		first, last = strings.TrimSpace(first), strings.TrimSpace(last)
+
+		var rng byteRange
+		if first == "" {
+			// Suffix range.
+			n, err := strconv.ParseInt(last, 10, 64)
+			if err != nil || n < 0 {
+				return nil, false
+			}
+			if n == 0 || size == 0 {
+				continue
+			}
+			rng = byteRange{start: max(size-n, 0), end: size}
+		} else {
+			start, err := strconv.ParseInt(first, 10, 64)
+			if err != nil || start < 0 {
+				return nil, false
+			}
+			if start >= size {
+				continue
+			}
+			end := size
+			if last != "" {
+				n, err := strconv.ParseInt(last, 10, 64)
+				if err != nil || n < start {
+					return nil, false
+				}
+				end = min(n+1, size)
+			}
+			rng = byteRange{start: start, end: end}
+		}
+		ranges = append(ranges, rng)
+	}
+	return ranges, len(ranges) > 0
+}
+
+// expandRanges widens the ranges to whole records of recordSize bytes starting at dataOffset.
+// A range touching the header covers the whole header. Overlapping ranges are merged.
+func expandRanges(ranges []byteRange, dataOffset, recordSize, size int64) []byteRange {
+	expanded := make([]byteRange, 0, len(ranges))
+	for _, rng := range ranges {
+		if rng.start < dataOffset {
+			rng.start = 0
+		} else {
+			rng.start = dataOffset + (rng.start-dataOffset)/recordSize*recordSize
+		}
+		if rng.end <= dataOffset {
+			rng.end = dataOffset
+		} else {
+			rng.end = dataOffset + (rng.end-dataOffset+recordSize-1)/recordSize*recordSize
+		}
+		rng.end = min(rng.end, size)
+		expanded = append(expanded, rng)
+	}
+
+	slices.SortFunc(expanded, func(a, b byteRange) int {
+		return cmp.Compare(a.start, b.start)
+	})
+	merged := expanded[:1]
+	for _, rng := range expanded[1:] {
+		if prev := &merged[len(merged)-1]; rng.start <= prev.end {
+			prev.end = max(prev.end, rng.end)
+		} else {
+			merged = append(merged, rng)
+		}
+	}
+	return merged
+}
+
+// formatRanges formats ranges as a Range header value.
+func formatRanges(ranges []byteRange) string {
+	parts := make([]string, len(ranges))
+	for i, rng := range ranges {
+		parts[i] = strconv.FormatInt(rng.start, 10) + "-" + strconv.FormatInt(rng.end-1, 10)
+	}
+	return "bytes=" + strings.Join(parts, ",")
+}
@@ -0,0 +1,322 @@
+/*
+ * Copyright (c) 2019 Zenichi Amano
+ *
+ * This file is part of http-ece, which is MIT licensed.
+ * See http://opensource.org/licenses/MIT
+ */
+
+package httpece
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func rangeContent(t *testing.T, opts ...Option) (plaintext, content []byte) {
+	plaintext = make([]byte, 10000)
+	for i := range plaintext {
+		plaintext[i] = byte(i)
+	}
+	content, err := Encrypt(plaintext, append([]Option{
+		WithKey([]byte("0123456789abcdef")),
+		WithKeyID([]byte("a1")),
+		WithRecordSize(100),
+	}, opts...)...)
+	assert.Nil(t, err)
+	return plaintext, content
+}
+
+func serveRange(content []byte, mode RangeMode, header http.Header, opts ...Option) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	rec := httptest.NewRecorder()
+	rec.Header().Set(headerETag, `"v1"`)
+	ServeContent(rec, req, "data.bin", time.Time{}, bytes.NewReader(content), int64(len(content)), mode, opts...)
+	return rec
+}
+
+func readParts(t *testing.T, rec *httptest.ResponseRecorder) map[string][]byte {
+	mediaType, params, err := mime.ParseMediaType(rec.Header().Get("Content-Type"))
+	assert.Nil(t, err)
+	assert.Equal(t, "multipart/byteranges", mediaType)
+
+	parts := map[string][]byte{}
+	mr := multipart.NewReader(rec.Body, params["boundary"])
+	for {
+		part, err := mr.NextPart()
+		if err == io.EOF {
+			return parts
+		}
+		assert.Nil(t, err)
+		body, err := io.ReadAll(part)
+		assert.Nil(t, err)
+		parts[part.Header.Get("Content-Range")] = body
+	}
+}
+
+func TestServeContent_Ciphertext(t *testing.T) {
+	_, content := rangeContent(t)
+	size := len(content)
+	headerLen := headerLenMin + 2
+
+	rec := serveRange(content, RangeCiphertext, http.Header{"Range": {"bytes=150-160"}})
+	assert.Equal(t, http.StatusPartialContent, rec.Code)
+	assert.Equal(t, "aes128gcm", rec.Header().Get(headerContentEncoding))
+	// The range grows to the record holding it.
+	start := headerLen + 100
+	assert.Equal(t, fmt.Sprintf("bytes %d-%d/%d", start, start+99, size), rec.Header().Get("Content-Range"))
+	assert.Equal(t, content[start:start+100], rec.Body.Bytes())
+
+	rec = serveRange(content, RangeCiphertext, http.Header{"Range": {"bytes=0-5, 500-510, -10"}})
+	assert.Equal(t, http.StatusPartialContent, rec.Code)
+	last := headerLen + (size-headerLen-1)/100*100
+	assert.Equal(t, map[string][]byte{
+		fmt.Sprintf("bytes 0-%d/%d", headerLen-1, size):                   content[:headerLen],
+		fmt.Sprintf("bytes %d-%d/%d", headerLen+400, headerLen+499, size): content[headerLen+400 : headerLen+500],
+		fmt.Sprintf("bytes %d-%d/%d", last, size-1, size):                 content[last:],
+	}, readParts(t, rec))
+
+	// Ranges beyond the content are dropped, the others still grow.
+	rec = serveRange(content, RangeCiphertext, http.Header{"Range": {"bytes=150-160,999999-"}})
+	assert.Equal(t, http.StatusPartialContent, rec.Code)
+	assert.Equal(t, fmt.Sprintf("bytes %d-%d/%d", start, start+99, size), rec.Header().Get("Content-Range"))
+
+	rec = serveRange(content, RangeCiphertext, http.Header{})
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, content, rec.Body.Bytes())
+}
+
+func TestServeContent_CiphertextDecryptable(t *testing.T) {
+	key := []byte("0123456789abcdef")
+	plaintext, content := rangeContent(t)
+	rec := serveRange(content, RangeCiphertext, http.Header{"Range": {"bytes=0-0, 5000-5000"}})
+	parts := readParts(t, rec)
+	assert.Len(t, parts, 2)
+
+	// A part and the header decrypt on their own, since the records are whole.
+	header, _, err := ParseHeader(content)
+	assert.Nil(t, err)
+	for contentRange, part := range parts {
+		var start int
+		_, err := fmt.Sscanf(contentRange, "bytes %d-", &start)
+		assert.Nil(t, err)
+		if start == 0 {
+			continue
+		}
+		index := (start - header.Len()) / 100
+		opt, err := parseOptions(decrypt, []Option{WithKey(key), WithHeader(header)})
+		assert.Nil(t, err)
+		header.apply(opt)
+		state, err := newDecryptState(opt)
+		assert.Nil(t, err)
+		result, err := state.openRecordAt(nil, part, uint32(index), false)
+		assert.Nil(t, err)
+		assert.Equal(t, plaintext[index*83:(index+1)*83], result)
+	}
+}
+
+func TestServeContent_IfRange(t *testing.T) {
+	_, content := rangeContent(t)
+
+	rec := serveRange(content, RangeCiphertext, http.Header{"Range": {"bytes=150-160"}, "If-Range": {`"v2"`}})
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, content, rec.Body.Bytes())
+
+	rec = serveRange(content, RangePlaintext, http.Header{"Range": {"bytes=150-160"}, "If-Range": {`"v1"`}}, WithKey([]byte("0123456789abcdef")))
+	assert.Equal(t, http.StatusPartialContent, rec.Code)
+
+	rec = serveRange(content, RangeCiphertext, http.Header{"If-None-Match": {`"v1"`}})
+	assert.Equal(t, http.StatusNotModified, rec.Code)
+}
+
+func TestServeContent_Plaintext(t *testing.T) {
+	key := []byte("0123456789abcdef")
+
+	for _, padSize := range []int{0, 500} {
+		t.Run(fmt.Sprint(padSize), func(t *testing.T) {
+			plaintext, content := rangeContent(t, WithPadSize(padSize))
+			opts := []Option{WithKey(key)}
+
+			rec := serveRange(content, RangePlaintext, http.Header{"Range": {"bytes=5000-5009"}}, opts...)
+			assert.Equal(t, http.StatusPartialContent, rec.Code)
+			assert.Equal(t, "", rec.Header().Get(headerContentEncoding))
+			assert.Equal(t, "bytes 5000-5009/10000", rec.Header().Get("Content-Range"))
+			assert.Equal(t, plaintext[5000:5010], rec.Body.Bytes())
+
+			rec = serveRange(content, RangePlaintext, http.Header{"Range": {"bytes=0-1,-3"}}, opts...)
+			assert.Equal(t, map[string][]byte{
+				"bytes 0-1/10000":       plaintext[:2],
+				"bytes 9997-9999/10000": plaintext[9997:],
+			}, readParts(t, rec))
+
+			rec = serveRange(content, RangePlaintext, http.Header{}, opts...)
+			assert.Equal(t, http.StatusOK, rec.Code)
+			assert.Equal(t, plaintext, rec.Body.Bytes())
+		})
+	}
+}
+
+// countingReaderAt counts the reads of the records.
+type countingReaderAt struct {
+	*bytes.Reader
+	reads int
+}
+
+func (r *countingReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	r.reads++
+	return r.Reader.ReadAt(p, off)
+}
+
+func TestServeContent_PlaintextRecords(t *testing.T) {
+	_, content := rangeContent(t)
+	r := &countingReaderAt{Reader: bytes.NewReader(content)}
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("Range", "bytes=5000-5009")
+	rec := httptest.NewRecorder()
+	ServeContent(rec, req, "data.bin", time.Time{}, r, int64(len(content)), RangePlaintext,
+		WithKey([]byte("0123456789abcdef")), WithUnpaddedRecords(true))
+	assert.Equal(t, http.StatusPartialContent, rec.Code)
+	// The header and its key ID, the first record for the key, the last record for the size,
+	// and the record holding the range.
+	assert.Equal(t, 5, r.reads)
+}
+
+func TestDecryptor_ServeContentIndex(t *testing.T) {
+	_, content := rangeContent(t, WithPadSize(500))
+	dec, err := NewDecryptor(WithKey([]byte("0123456789abcdef")))
+	assert.Nil(t, err)
+	modTime := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	serve := func(modTime time.Time) int {
+		r := &countingReaderAt{Reader: bytes.NewReader(content)}
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set("Range", "bytes=-10")
+		rec := httptest.NewRecorder()
+		dec.ServeContent(rec, req, "data.bin", modTime, r, int64(len(content)), RangePlaintext)
+		assert.Equal(t, http.StatusPartialContent, rec.Code)
+		assert.Equal(t, "bytes 9990-9999/10000", rec.Header().Get("Content-Range"))
+		return r.reads
+	}
+
+	// The first request decrypts every record to learn the size.
+	records := (len(content) + 99) / 100
+	assert.Greater(t, serve(modTime), records)
+	// Later requests read the header and its key ID, the first record for the key, and the last record.
+	assert.Equal(t, 4, serve(modTime))
+	// Without a modification time nothing is kept.
+	assert.Greater(t, serve(time.Time{}), records)
+	assert.Greater(t, serve(time.Time{}), records)
+}
+
+func TestServeContent_PlaintextPadded(t *testing.T) {
+	key := []byte("0123456789abcdef")
+	plaintext := make([]byte, 500)
+	for i := range plaintext {
+		plaintext[i] = 'a' + byte(i%26)
+	}
+
+	// Padding from WithPadSize, and from Flush as EncryptResponseHandler uses it.
+	padded, err := Encrypt(plaintext, WithKey(key), WithRecordSize(100), WithPadSize(77))
+	assert.Nil(t, err)
+	var flushed bytes.Buffer
+	w, err := NewEncryptWriter(&flushed, WithKey(key), WithRecordSize(100))
+	assert.Nil(t, err)
+	for _, chunk := range [][]byte{plaintext[:10], plaintext[10:150], plaintext[150:]} {
+		_, err = w.Write(chunk)
+		assert.Nil(t, err)
+		assert.Nil(t, w.(interface{ Flush() error }).Flush())
+	}
+	assert.Nil(t, w.Close())
+
+	for name, content := range map[string][]byte{"padded": padded, "flushed": flushed.Bytes()} {
+		t.Run(name, func(t *testing.T) {
+			rec := serveRange(content, RangePlaintext, http.Header{"Range": {"bytes=100-120"}}, WithKey(key))
+			assert.Equal(t, http.StatusPartialContent, rec.Code)
+			assert.Equal(t, "bytes 100-120/500", rec.Header().Get("Content-Range"))
+			assert.Equal(t, plaintext[100:121], rec.Body.Bytes())
+		})
+	}
+}
+
+func TestDecryptor_ServeContent(t *testing.T) {
+	plaintext, content := rangeContent(t)
+	dec, err := NewDecryptor(WithKey([]byte("0123456789abcdef")))
+	assert.Nil(t, err)
+
+	for _, mode := range []RangeMode{RangeCiphertext, RangePlaintext, RangePlaintext} {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set("Range", "bytes=5000-5009")
+		rec := httptest.NewRecorder()
+		dec.ServeContent(rec, req, "data.bin", time.Time{}, bytes.NewReader(content), int64(len(content)), mode)
+		assert.Equal(t, http.StatusPartialContent, rec.Code)
+		if mode == RangePlaintext {
+			assert.Equal(t, plaintext[5000:5010], rec.Body.Bytes())
+		}
+	}
+	// The key of the content is derived once.
+	assert.Equal(t, 1, dec.opt.cipherCache.len())
+
+	rec := httptest.NewRecorder()
+	dec.ServeContent(rec, httptest.NewRequest(http.MethodGet, "/", nil), "data.bin", time.Time{},
+		bytes.NewReader(content), int64(len(content)), RangePlaintext, WithKey([]byte("fedcba9876543210")))
+	assert.Equal(t, http.StatusInternalServerError, rec.Code)
+}
+
+func TestServeContent_Errors(t *testing.T) {
+	_, content := rangeContent(t)
+
+	rec := serveRange(content[:10], RangeCiphertext, http.Header{})
+	assert.Equal(t, http.StatusInternalServerError, rec.Code)
+	rec = serveRange(content, RangePlaintext, http.Header{}, WithKey([]byte("fedcba9876543210")))
+	assert.Equal(t, http.StatusInternalServerError, rec.Code)
+	rec = serveRange(content, RangeCiphertext, http.Header{"Range": {"bytes=100000-"}})
+	assert.Equal(t, http.StatusRequestedRangeNotSatisfiable, rec.Code)
+}
+
+func TestParseRanges(t *testing.T) {
+	tests := []struct {
+		value    string
+		expected []byteRange
+	}{
+		{value: "bytes=0-9", expected: []byteRange{{0, 10}}},
+		{value: "bytes=5-", expected: []byteRange{{5, 100}}},
+		{value: "bytes=-10, 90-200", expected: []byteRange{{90, 100}, {90, 100}}},
+		{value: "bytes=-200", expected: []byteRange{{0, 100}}},
+		{value: "bytes=50-60, 100-, -0", expected: []byteRange{{50, 61}}},
+		{value: "bytes=150-, 5-4"},
+		{value: "bytes=100-"},
+		{value: "bytes=5-4"},
+		{value: "bytes=-0"},
+		{value: "bytes=a-b"},
+		{value: "bytes=5"},
+		{value: "bytes="},
+		{value: "items=0-1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			ranges, ok := parseRanges(tt.value, 100)
+			assert.Equal(t, tt.expected != nil, ok)
+			assert.Equal(t, tt.expected, ranges)
+		})
+	}
+}
+
+func TestExpandRanges(t *testing.T) {
+	assert.Equal(t, []byteRange{{0, 10}, {30, 50}, {70, 75}},
+		expandRanges([]byteRange{{72, 73}, {31, 32}, {0, 1}, {45, 50}}, 10, 20, 75))
+	assert.Equal(t, []byteRange{{0, 30}}, expandRanges([]byteRange{{5, 12}, {10, 29}}, 10, 20, 75))
+	assert.Equal(t, "bytes=0-9,30-49", formatRanges([]byteRange{{0, 10}, {30, 50}}))
+	assert.Equal(t, "bytes=0-0", formatRanges([]byteRange{{0, 1}}))
+}
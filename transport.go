@@ -0,0 +1,201 @@
+/*
+ * Copyright (c) 2019 Zenichi Amano
+ *
+ * This file is part of http-ece, which is MIT licensed.
+ * See http://opensource.org/licenses/MIT
+ */
+
+package httpece
+
+import (
+	"io"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// Transport is an http.RoundTripper that encrypts request bodies with aes128gcm and decrypts aes128gcm responses.
+// A Transport must not be copied or changed after first use.
+type Transport struct {
+	// Base is the RoundTripper that sends the requests. http.DefaultTransport is used if nil.
+	Base http.RoundTripper
+
+	// Options apply to both directions. They are parsed on first use, so that derived keys are cached.
+	Options []Option
+	// EncryptOptions and DecryptOptions add options per request, e.g. the key of the recipient; either may be nil.
+	EncryptOptions RequestOptionsFunc
+	DecryptOptions RequestOptionsFunc
+
+	once sync.Once
+	enc  *Encryptor
+	dec  *Decryptor
+	err  error // error in Options
+}
+
+// RoundTrip encrypts the body of req, sends it, and decrypts the response.
+// Requests without a body, or with a body encoded with aes128gcm already, are sent as is.
+// aes128gcm is added to Accept-Encoding, together with the coding of WithCompression,
+// which is applied to request bodies before encryption unless they have a coding already.
+// Responses with aes128gcm have all their content codings removed, except for 206 responses,
+// which hold ranges of the ciphertext without its header and are returned as is.
+// With WithDigestAlgorithm, request bodies carry their digest in a Content-Digest trailer.
+// The Content-Digest or Repr-Digest of decrypted responses is checked at the end of the body, and removed.
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	closeBody := func() {
+		if req.Body != nil {
+			_ = req.Body.Close()
+		}
+	}
+
+	decOpt, err := t.options(decrypt, t.DecryptOptions, req)
+	if err != nil {
+		closeBody()
+		return nil, err
+	}
+
+	out := req.Clone(req.Context())
+	encoded := slices.Contains(parseCodings(req.Header.Values(headerContentEncoding)), string(AES128GCM))
+	if req.Body != nil && req.Body != http.NoBody && !encoded {
+		compress := req.Header.Get(headerContentEncoding) == ""
+		encodeBody := func(rc io.ReadCloser) (io.ReadCloser, int64, []string, error) {
+			opt, err := t.options(encrypt, t.EncryptOptions, req)
+			if err != nil {
+				return nil, 0, nil, err
+			}
+			if opt.digestAlg != "" {
+				if opt.digest, err = NewDigest(opt.digestAlg); err != nil {
+					return nil, 0, nil, err
+				}
+			}
+
+			var body io.ReadCloser
+			contentLength := int64(-1)
+			codings := []string{string(AES128GCM)}
+			if compress && opt.compression != "" {
+				codings = []string{opt.compression, string(AES128GCM)}
+				body = pipeEncoded(rc, codings, func(w io.Writer) (io.WriteCloser, error) {
+					return newEncryptWriter(w, opt)
+				})
+			} else {
+				if req.ContentLength > 0 {
+					n, err := encryptedLen(opt, int(req.ContentLength))
+					if err != nil {
+						return nil, 0, nil, err
+					}
+					contentLength = int64(n)
+				}
+				body = readCloser{newEncryptReader(rc, opt), rc}
+			}
+
+			if opt.digest != nil {
+				// Trailers are only sent with a body of unknown length.
+				if out.Trailer == nil {
+					out.Trailer = make(http.Header)
+				}
+				out.Trailer[headerContentDigest] = nil
+				body, contentLength = &trailerBody{ReadCloser: body, trailer: out.Trailer, digest: opt.digest}, -1
+			}
+			return body, contentLength, codings, nil
+		}
+
+		delDigest(out.Header, out.Trailer)
+		body, contentLength, codings, err := encodeBody(req.Body)
+		if err != nil {
+			closeBody()
+			return nil, err
+		}
+		out.Body, out.ContentLength = body, contentLength
+		if req.GetBody != nil {
+			out.GetBody = func() (io.ReadCloser, error) {
+				rc, err := req.GetBody()
+				if err != nil {
+					return nil, err
+				}
+				body, _, _, err := encodeBody(rc)
+				return body, err
+			}
+		}
+		out.Header.Add(headerContentEncoding, strings.Join(codings, ", "))
+		out.Header.Del(headerContentLength)
+	}
+	for _, coding := range []string{decOpt.compression, string(AES128GCM)} {
+		if coding != "" && !acceptsCoding(out.Header.Values(headerAcceptEncoding), coding) {
+			out.Header.Add(headerAcceptEncoding, coding)
+		}
+	}
+
+	res, err := t.base().RoundTrip(out)
+	if err != nil {
+		return nil, err
+	}
+
+	codings := parseCodings(res.Header.Values(headerContentEncoding))
+	if req.Method == http.MethodHead || !bodyAllowed(res.StatusCode) || res.StatusCode == http.StatusPartialContent ||
+		!slices.Contains(codings, string(AES128GCM)) {
+		return res, nil
+	}
+
+	body := verifyBody(res.Body, res.Header, res.Trailer, false)
+	r, err := decodeReader(body, codings, func(r io.Reader) (io.Reader, error) {
+		return newDecryptReader(r, decOpt)
+	})
+	if err != nil {
+		_ = res.Body.Close()
+		return nil, err
+	}
+	res.Body = readCloser{r, res.Body}
+	res.ContentLength = -1
+	res.Uncompressed = true
+	res.Header.Del(headerContentEncoding)
+	res.Header.Del(headerContentLength)
+	delDigest(res.Header, res.Trailer)
+	return res, nil
+}
+
+// trailerBody sets the digest of the body in the trailer at the end of the body.
+type trailerBody struct {
+	io.ReadCloser
+	trailer http.Header
+	digest  *Digest
+}
+
+func (b *trailerBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if err == io.EOF {
+		b.trailer.Set(headerContentDigest, b.digest.String())
+	}
+	return n, err
+}
+
+// options returns the options for a message exchanged with req.
+func (t *Transport) options(mode mode, fn RequestOptionsFunc, req *http.Request) (*options, error) {
+	t.once.Do(func() {
+		opts := pinEncoding(t.Options)
+		if t.enc, t.err = newEncryptor(opts); t.err == nil {
+			t.dec, t.err = NewDecryptor(opts...)
+		}
+	})
+	if t.err != nil {
+		return nil, t.err
+	}
+
+	var reqOpts []Option
+	if fn != nil {
+		var err error
+		if reqOpts, err = fn(req); err != nil {
+			return nil, err
+		}
+	}
+	if mode == encrypt {
+		return t.enc.options(pinEncoding(reqOpts))
+	}
+	return t.dec.opt.clone(pinEncoding(reqOpts))
+}
+
+func (t *Transport) base() http.RoundTripper {
+	if t.Base != nil {
+		return t.Base
+	}
+	return http.DefaultTransport
+}
@@ -0,0 +1,300 @@
+/*
+ * Copyright (c) 2019 Zenichi Amano
+ *
+ * This file is part of http-ece, which is MIT licensed.
+ * See http://opensource.org/licenses/MIT
+ */
+
+package httpece
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type roundTripFunc func(req *http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+func TestTransport(t *testing.T) {
+	requestKey := []byte("0123456789abcdef")
+	responseKey := []byte("fedcba9876543210")
+	plaintext := strings.Repeat("a", 10000)
+
+	var handler http.Handler = echoHandler(t)
+	handler = EncryptResponseHandler(handler, nil, WithKey(responseKey))
+	handler = DecryptRequestHandler(handler, WithKey(requestKey))
+	server := httptest.NewServer(handler)
+	defer server.Close()
+
+	client := &http.Client{Transport: &Transport{
+		Base: server.Client().Transport,
+		EncryptOptions: func(r *http.Request) ([]Option, error) {
+			return []Option{WithKey(requestKey)}, nil
+		},
+		DecryptOptions: func(r *http.Request) ([]Option, error) {
+			return []Option{WithKey(responseKey)}, nil
+		},
+		Options: []Option{WithRecordSize(1000)},
+	}}
+
+	res, err := client.Post(server.URL, "text/plain", strings.NewReader(plaintext))
+	assert.Nil(t, err)
+	defer res.Body.Close()
+	body, err := io.ReadAll(res.Body)
+	assert.Nil(t, err)
+	assert.Equal(t, plaintext, string(body))
+	assert.Equal(t, "", res.Header.Get(headerContentEncoding))
+	assert.Equal(t, int64(-1), res.ContentLength)
+	assert.True(t, res.Uncompressed)
+
+	res, err = client.Get(server.URL)
+	assert.Nil(t, err)
+	defer res.Body.Close()
+	body, err = io.ReadAll(res.Body)
+	assert.Nil(t, err)
+	assert.Equal(t, "", string(body))
+}
+
+func TestTransport_Request(t *testing.T) {
+	key := []byte("0123456789abcdef")
+	plaintext := strings.Repeat("a", 10000)
+
+	var sent *http.Request
+	transport := &Transport{
+		Base: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+			sent = req
+			return &http.Response{StatusCode: http.StatusOK, Header: http.Header{}, Body: http.NoBody}, nil
+		}),
+		Options: []Option{WithKey(key)},
+	}
+
+	req, err := http.NewRequest(http.MethodPut, "http://example.com/", bytes.NewReader([]byte(plaintext)))
+	assert.Nil(t, err)
+	req.Header.Set(headerAcceptEncoding, "gzip")
+	res, err := transport.RoundTrip(req)
+	assert.Nil(t, err)
+	assert.Nil(t, res.Body.Close())
+
+	assert.Equal(t, "aes128gcm", sent.Header.Get(headerContentEncoding))
+	assert.Equal(t, []string{"gzip", "aes128gcm"}, sent.Header.Values(headerAcceptEncoding))
+	assert.Equal(t, "gzip", req.Header.Get(headerAcceptEncoding))
+
+	content, err := io.ReadAll(sent.Body)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(len(content)), sent.ContentLength)
+	result, err := Decrypt(content, WithKey(key))
+	assert.Nil(t, err)
+	assert.Equal(t, plaintext, string(result))
+
+	// The body can be replayed.
+	rc, err := sent.GetBody()
+	assert.Nil(t, err)
+	content, err = io.ReadAll(rc)
+	assert.Nil(t, err)
+	result, err = Decrypt(content, WithKey(key))
+	assert.Nil(t, err)
+	assert.Equal(t, plaintext, string(result))
+}
+
+func TestTransport_Encoded(t *testing.T) {
+	key := []byte("0123456789abcdef")
+	content, err := Encrypt([]byte("hello"), WithKey(key))
+	assert.Nil(t, err)
+
+	var sent []byte
+	transport := &Transport{
+		Base: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+			sent, err = io.ReadAll(req.Body)
+			assert.Nil(t, err)
+			assert.Equal(t, "aes128gcm", req.Header.Get(headerContentEncoding))
+			return &http.Response{StatusCode: http.StatusOK, Header: http.Header{}, Body: http.NoBody}, nil
+		}),
+		Options: []Option{WithKey(key)},
+	}
+
+	req, err := http.NewRequest(http.MethodPut, "http://example.com/", bytes.NewReader(content))
+	assert.Nil(t, err)
+	req.Header.Set(headerContentEncoding, "aes128gcm")
+	res, err := transport.RoundTrip(req)
+	assert.Nil(t, err)
+	assert.Nil(t, res.Body.Close())
+	assert.Equal(t, content, sent)
+}
+
+func TestTransport_Options(t *testing.T) {
+	authSecret := d(t, "9HcXsQe3xLMG/w2HsYKrOA==")
+	privateKey := d(t, "/oQYbac5yEOeOeg+5D0QxOaB1YtiyONxkqmxU3+tq58=")
+	peersPublicKey := d(t, "BBixsHNhTqN5jYhpguokCWQhGKoZlroyEj6GYr6hy79z1IeTEQdupEsW6xooLvM170j9Ekss3amjhOmDNP5Pi0s=")
+	receiverPrivateKey := d(t, "yfSYB+/vCEoWklHCG7F99cQ1vRwemFYn87jZc8PHBwU=")
+
+	var sent [][]byte
+	transport := &Transport{
+		Base: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+			assert.Equal(t, "aes128gcm", req.Header.Get(headerContentEncoding))
+			content, err := io.ReadAll(req.Body)
+			assert.Nil(t, err)
+			sent = append(sent, content)
+			return &http.Response{StatusCode: http.StatusOK, Header: http.Header{}, Body: http.NoBody}, nil
+		}),
+		Options: []Option{WithEncoding(AESGCM), WithAuthSecret(authSecret), WithPrivate(privateKey)},
+		EncryptOptions: func(r *http.Request) ([]Option, error) {
+			return []Option{WithEncoding(AESGCM), WithDh(peersPublicKey)}, nil
+		},
+	}
+
+	for range 2 {
+		req, err := http.NewRequest(http.MethodPut, "http://example.com/", strings.NewReader("hello"))
+		assert.Nil(t, err)
+		res, err := transport.RoundTrip(req)
+		assert.Nil(t, err)
+		assert.Nil(t, res.Body.Close())
+	}
+	// The shared secret is derived once.
+	assert.Equal(t, 1, transport.enc.opt.secretCache.len())
+	for _, content := range sent {
+		plaintext, err := Decrypt(content, WithAuthSecret(authSecret), WithPrivate(receiverPrivateKey))
+		assert.Nil(t, err)
+		assert.Equal(t, "hello", string(plaintext))
+	}
+
+	transport = &Transport{Options: []Option{WithSalt(make([]byte, 16))}}
+	for range 2 {
+		req, err := http.NewRequest(http.MethodGet, "http://example.com/", nil)
+		assert.Nil(t, err)
+		_, err = transport.RoundTrip(req)
+		assert.NotNil(t, err)
+	}
+}
+
+func TestTransport_PassThrough(t *testing.T) {
+	transport := &Transport{
+		Base: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+			assert.Nil(t, req.Body)
+			return &http.Response{
+				StatusCode:    http.StatusOK,
+				Header:        http.Header{headerContentEncoding: {"gzip"}},
+				Body:          io.NopCloser(strings.NewReader("compressed")),
+				ContentLength: 10,
+			}, nil
+		}),
+		Options: []Option{WithKey([]byte("0123456789abcdef"))},
+	}
+
+	req, err := http.NewRequest(http.MethodGet, "http://example.com/", nil)
+	assert.Nil(t, err)
+	res, err := transport.RoundTrip(req)
+	assert.Nil(t, err)
+	assert.Equal(t, "gzip", res.Header.Get(headerContentEncoding))
+	assert.Equal(t, int64(10), res.ContentLength)
+}
+
+func TestTransport_PartialContent(t *testing.T) {
+	key := []byte("0123456789abcdef")
+	content, err := Encrypt(bytes.Repeat([]byte("a"), 1000), WithKey(key), WithKeyID([]byte("a1")), WithRecordSize(100))
+	assert.Nil(t, err)
+	server := httptest.NewServer(FileServer(fstest.MapFS{"a.txt.ece": {Data: content}}, nil))
+	defer server.Close()
+
+	client := &http.Client{Transport: &Transport{Base: server.Client().Transport, Options: []Option{WithKey(key)}}}
+	req, err := http.NewRequest(http.MethodGet, server.URL+"/a.txt", nil)
+	assert.Nil(t, err)
+	req.Header.Set(headerRange, "bytes=200-250")
+	res, err := client.Do(req)
+	assert.Nil(t, err)
+	defer res.Body.Close()
+
+	// The range of the ciphertext is returned as is.
+	assert.Equal(t, http.StatusPartialContent, res.StatusCode)
+	assert.Equal(t, "aes128gcm", res.Header.Get(headerContentEncoding))
+	body, err := io.ReadAll(res.Body)
+	assert.Nil(t, err)
+	start := headerLenMin + 2 + 100
+	assert.Equal(t, content[start:start+200], body)
+}
+
+func TestTransport_Errors(t *testing.T) {
+	content, err := Encrypt([]byte("plaintext"), WithKey([]byte("0123456789abcdef")))
+	assert.Nil(t, err)
+	base := roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		return &http.Response{
+			StatusCode: http.StatusOK,
+			Header:     http.Header{headerContentEncoding: {"aes128gcm"}},
+			Body:       io.NopCloser(bytes.NewReader(content[:10])),
+		}, nil
+	})
+
+	transport := &Transport{
+		Base: base,
+		EncryptOptions: func(r *http.Request) ([]Option, error) {
+			return nil, errors.New("unknown recipient")
+		},
+		Options: []Option{WithKey([]byte("0123456789abcdef"))},
+	}
+	req, err := http.NewRequest(http.MethodPost, "http://example.com/", strings.NewReader("body"))
+	assert.Nil(t, err)
+	_, err = transport.RoundTrip(req)
+	assert.EqualError(t, err, "unknown recipient")
+
+	transport.EncryptOptions = nil
+	req, err = http.NewRequest(http.MethodGet, "http://example.com/", nil)
+	assert.Nil(t, err)
+	_, err = transport.RoundTrip(req)
+	assert.ErrorIs(t, err, ErrTruncated)
+}
+
+func TestTransport_Compression(t *testing.T) {
+	key := []byte("0123456789abcdef")
+	plaintext := strings.Repeat("hello world ", 1000)
+
+	var requestEncoding, responseEncoding string
+	var handler http.Handler = echoHandler(t)
+	handler = EncryptResponseHandler(handler, nil, WithKey(key), WithCompression("gzip"))
+	handler = DecryptRequestHandler(handler, WithKey(key))
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requestEncoding = r.Header.Get(headerContentEncoding)
+		handler.ServeHTTP(w, r)
+		responseEncoding = w.Header().Get(headerContentEncoding)
+	}))
+	defer server.Close()
+
+	client := &http.Client{Transport: &Transport{
+		Base:    server.Client().Transport,
+		Options: []Option{WithKey(key), WithCompression("gzip")},
+	}}
+	res, err := client.Post(server.URL, "text/plain", strings.NewReader(plaintext))
+	assert.Nil(t, err)
+	defer res.Body.Close()
+	body, err := io.ReadAll(res.Body)
+	assert.Nil(t, err)
+	assert.Equal(t, plaintext, string(body))
+	assert.Equal(t, "gzip, aes128gcm", requestEncoding)
+	assert.Equal(t, "gzip, aes128gcm", responseEncoding)
+}
+
+func TestTransport_UnsupportedCoding(t *testing.T) {
+	transport := &Transport{
+		Base: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+			return &http.Response{
+				StatusCode: http.StatusOK,
+				Header:     http.Header{headerContentEncoding: {"br, aes128gcm"}},
+				Body:       http.NoBody,
+			}, nil
+		}),
+		Options: []Option{WithKey([]byte("0123456789abcdef"))},
+	}
+	req, err := http.NewRequest(http.MethodGet, "http://example.com/", nil)
+	assert.Nil(t, err)
+	_, err = transport.RoundTrip(req)
+	assert.ErrorIs(t, err, ErrUnsupportedCoding)
+}
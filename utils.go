@@ -14,8 +14,6 @@ import (
 	"log"
 )
 
-const maxInt = int(^uint(0) >> 1)
-
 const debug = debugT(false)
 
 type debugT bool
@@ -39,6 +37,7 @@ func parseOptions(mode mode, opts []Option) (*options, error) {
 		recordSize: recordSizeDefault,
 		keyLabel:   curveAlgorithm,
 		keyMap:     func(bytes []byte) []byte { return nil },
+		cacheSize:  cacheSizeDefault,
 	}
 
 	var err error
@@ -55,66 +54,35 @@ func parseOptions(mode mode, opts []Option) (*options, error) {
 	return opt, nil
 }
 
-func uint16ToBytes(i uint16) []byte {
-	x := make([]byte, 2)
-	binary.BigEndian.PutUint16(x, i)
-	return x
-}
+// clone returns a copy of the options with opts applied.
+func (o *options) clone(opts []Option) (*options, error) {
+	opt := *o
+	for _, f := range opts {
+		if err := f(&opt); err != nil {
+			return nil, err
+		}
+	}
 
-func uint32ToBytes(i uint32) []byte {
-	x := make([]byte, 4)
-	binary.BigEndian.PutUint32(x, i)
-	return x
-}
+	if err := opt.initialize(); err != nil {
+		return nil, err
+	}
 
-func generateNonce(baseNonce []byte, counter uint32) []byte {
-	x := make([]byte, nonceLen)
-	binary.BigEndian.PutUint32(x[8:], counter)
-	xor12(x, baseNonce, x)
-	return x
+	return &opt, nil
 }
 
-func xor12(dst []byte, a []byte, b []byte) {
-	_ = dst[11]
-	_ = a[11]
-	_ = b[11]
-
-	dst[0] = a[0] ^ b[0]
-	dst[1] = a[1] ^ b[1]
-	dst[2] = a[2] ^ b[2]
-	dst[3] = a[3] ^ b[3]
-	dst[4] = a[4] ^ b[4]
-	dst[5] = a[5] ^ b[5]
-	dst[6] = a[6] ^ b[6]
-	dst[7] = a[7] ^ b[7]
-	dst[8] = a[8] ^ b[8]
-	dst[9] = a[9] ^ b[9]
-	dst[10] = a[10] ^ b[10]
-	dst[11] = a[11] ^ b[11]
+func uint16ToBytes(i uint16) []byte {
+	x := make([]byte, 2)
+	binary.BigEndian.PutUint16(x, i)
+	return x
 }
 
-func join(s [][]byte) []byte {
-	if len(s) == 0 {
-		return []byte{}
-	}
-	if len(s) == 1 {
-		return append([]byte(nil), s[0]...)
-	}
-
-	var n int
-	for _, v := range s {
-		if len(v) > maxInt-n {
-			panic("join output length overflow")
-		}
-		n += len(v)
-	}
+// putNonce writes the nonce of the record counter into dst.
+func putNonce(dst []byte, baseNonce []byte, counter uint32) {
+	_ = dst[nonceLen-1]
+	_ = baseNonce[nonceLen-1]
 
-	b := make([]byte, n)
-	o := 0
-	for _, v := range s {
-		o += copy(b[o:], v)
-	}
-	return b
+	copy(dst, baseNonce[:nonceLen-4])
+	binary.BigEndian.PutUint32(dst[nonceLen-4:], binary.BigEndian.Uint32(baseNonce[nonceLen-4:])^counter)
 }
 
 func randomSalt() ([]byte, error) {
@@ -26,42 +26,29 @@ func TestUint16ToBytes(t *testing.T) {
 	f(0x01, 0x01)
 }
 
-func TestUint32ToBytes(t *testing.T) {
-	f := func(v ...byte) {
-		assert.Equal(t, v, uint32ToBytes(binary.BigEndian.Uint32(v)))
+func TestPutNonce(t *testing.T) {
+	nonce := make([]byte, nonceLen)
+	nonceOf := func(baseNonce []byte, counter uint32) []byte {
+		putNonce(nonce, baseNonce, counter)
+		return nonce
 	}
 
-	f(0x00, 0x00, 0x00, 0x01)
-	f(0x00, 0x00, 0x00, 0x11)
-	f(0x00, 0x00, 0x01, 0x01)
-	f(0x00, 0x01, 0x01, 0x01)
-	f(0x00, 0x11, 0x01, 0x01)
-	f(0x01, 0x01, 0x01, 0x01)
-}
-
-func TestGenerateNonce(t *testing.T) {
 	expect := []byte{0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00}
 	tmp := []byte{0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00}
-	assert.Equal(t, expect, generateNonce(tmp, 0))
+	assert.Equal(t, expect, nonceOf(tmp, 0))
 
 	expect = []byte{0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x01}
-	assert.Equal(t, expect, generateNonce(tmp, 1))
+	assert.Equal(t, expect, nonceOf(tmp, 1))
 
 	expect = []byte{0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02}
-	assert.Equal(t, expect, generateNonce(tmp, 2))
+	assert.Equal(t, expect, nonceOf(tmp, 2))
 
 	expect = []byte{0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x03}
-	assert.Equal(t, expect, generateNonce(tmp, 3))
+	assert.Equal(t, expect, nonceOf(tmp, 3))
 
 	tmp = []byte{0x12, 0x34, 0x56, 0x78, 0x9a, 0xbc, 0xde, 0xf0, 0x12, 0x34, 0x56, 0x78}
 	expect = []byte{0x12, 0x34, 0x56, 0x78, 0x9a, 0xbc, 0xde, 0xf0, 0xed, 0xcb, 0xa9, 0x87}
-	assert.Equal(t, expect, generateNonce(tmp, 0xffffffff))
-}
-
-func TestJoin(t *testing.T) {
-	assert.Equal(t, []byte{0x01}, join([][]byte{{0x01}}))
-	assert.Equal(t, []byte{0x01, 0x02}, join([][]byte{{0x01}, {0x02}}))
-	assert.Equal(t, []byte{0x01, 0x02, 0x03}, join([][]byte{{0x01}, {0x02}, {0x03}}))
+	assert.Equal(t, expect, nonceOf(tmp, 0xffffffff))
 }
 
 func TestRandomSalt(t *testing.T) {
@@ -0,0 +1,57 @@
+/*
+ * Copyright (c) 2019 Zenichi Amano
+ *
+ * This file is part of http-ece, which is MIT licensed.
+ * See http://opensource.org/licenses/MIT
+ */
+
+package webpush
+
+import (
+	"errors"
+
+	httpece "github.com/crow-misia/http-ece"
+)
+
+const (
+	// MaxMessageSize is the largest push message body that push services have to accept.
+	MaxMessageSize = 4096
+	// MaxPayloadSize is the largest payload that fits a push message without padding:
+	// the header with the 65-byte public key, the delimiter and the tag take 103 bytes.
+	MaxPayloadSize = MaxMessageSize - (16 + 4 + 1 + 65) - 1 - 16
+	// recordSize is the record size of push messages, which are a single record.
+	recordSize = 4096
+)
+
+// ErrPayloadTooLarge is returned for payloads that do not fit a push message.
+var ErrPayloadTooLarge = errors.New("push message payload too large")
+
+// EncryptFor encrypts payload for the subscription, and returns the body of the push message.
+//
+// The message follows RFC 8291: aes128gcm with a record size of 4096 in a single record,
+// keyed by an ephemeral application server key that is sent as the key ID.
+// Options are added after those of the subscription, e.g. WithPadSize to hide the payload length,
+// or WithPrivate and WithSalt for reproducible output in tests.
+func EncryptFor(sub *Subscription, payload []byte, opts ...httpece.Option) ([]byte, error) {
+	if err := sub.Validate(); err != nil {
+		return nil, err
+	}
+	if len(payload) > MaxPayloadSize {
+		return nil, ErrPayloadTooLarge
+	}
+
+	content, err := httpece.Encrypt(payload, append([]httpece.Option{
+		httpece.WithEncoding(httpece.AES128GCM),
+		httpece.WithRecordSize(recordSize),
+		httpece.WithDh(sub.Keys.P256dh),
+		httpece.WithAuthSecret(sub.Keys.Auth),
+	}, opts...)...)
+	if err != nil {
+		return nil, err
+	}
+	// Padding may have pushed the message beyond a single record.
+	if len(content) > MaxMessageSize {
+		return nil, ErrPayloadTooLarge
+	}
+	return content, nil
+}
@@ -0,0 +1,63 @@
+/*
+ * Copyright (c) 2019 Zenichi Amano
+ *
+ * This file is part of http-ece, which is MIT licensed.
+ * See http://opensource.org/licenses/MIT
+ */
+
+package webpush
+
+import (
+	"bytes"
+	"testing"
+
+	httpece "github.com/crow-misia/http-ece"
+	"github.com/stretchr/testify/assert"
+)
+
+func subscription(t *testing.T) *Subscription {
+	return &Subscription{
+		Endpoint: "https://push.example.net/push/JzLQ3raZJfFBR0aqvOMsLrt54w4rJUsV",
+		Keys:     Keys{P256dh: d(t, uaPublic), Auth: d(t, uaAuth)},
+	}
+}
+
+func TestEncryptFor(t *testing.T) {
+	// RFC 8291, appendix A.
+	content, err := EncryptFor(subscription(t), []byte("When I grow up, I want to be a watermelon"),
+		httpece.WithPrivate(d(t, "yfWPiYE-n46HLnH0KqZOF1fJJU3MYrct3AELtAQ-oRw")),
+		httpece.WithSalt(d(t, "DGv6ra1nlYgDCS1FRnbzlw")))
+	assert.Nil(t, err)
+	assert.Equal(t, d(t, "DGv6ra1nlYgDCS1FRnbzlwAAEABBBP4z9KsN6nGRTbVYI_c7VJSPQTBtkgcy27mlmlMoZIIgDll6e3vCYLocInmYWAmS6TlzAC8wEqKK6PBru3jl7A_yl95bQpu6cVPTpK4Mqgkf1CXztLVBSt2Ks3oZwbuwXPXLWyouBWLVWGNWQexSgSxsj_Qulcy4a-fN"), content)
+}
+
+func TestEncryptFor_Decrypt(t *testing.T) {
+	payload := bytes.Repeat([]byte("a"), MaxPayloadSize)
+	content, err := EncryptFor(subscription(t), payload)
+	assert.Nil(t, err)
+	assert.Len(t, content, MaxMessageSize)
+
+	h, _, err := httpece.ParseHeader(content)
+	assert.Nil(t, err)
+	assert.Equal(t, uint32(4096), h.RecordSize)
+	assert.Len(t, h.KeyID, 65)
+
+	plaintext, err := httpece.Decrypt(content,
+		httpece.WithPrivate(d(t, "q1dXpw3UpT5VOmu_cf_v6ih07Aems3njxI-JWgLcM94")),
+		httpece.WithAuthSecret(d(t, uaAuth)))
+	assert.Nil(t, err)
+	assert.Equal(t, payload, plaintext)
+}
+
+func TestEncryptFor_TooLarge(t *testing.T) {
+	_, err := EncryptFor(subscription(t), make([]byte, MaxPayloadSize+1))
+	assert.ErrorIs(t, err, ErrPayloadTooLarge)
+
+	_, err = EncryptFor(subscription(t), make([]byte, MaxPayloadSize), httpece.WithPadSize(1))
+	assert.ErrorIs(t, err, ErrPayloadTooLarge)
+
+	sub := subscription(t)
+	sub.Keys.Auth = nil
+	_, err = EncryptFor(sub, []byte("hello"))
+	assert.NotNil(t, err)
+}
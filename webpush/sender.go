@@ -0,0 +1,247 @@
+/*
+ * Copyright (c) 2019 Zenichi Amano
+ *
+ * This file is part of http-ece, which is MIT licensed.
+ * See http://opensource.org/licenses/MIT
+ */
+
+package webpush
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	httpece "github.com/crow-misia/http-ece"
+)
+
+const (
+	headerContentEncoding = "Content-Encoding"
+	headerContentType     = "Content-Type"
+	headerLocation        = "Location"
+	headerRetryAfter      = "Retry-After"
+	headerTTL             = "TTL"
+	headerTopic           = "Topic"
+	headerUrgency         = "Urgency"
+
+	// topicLenMax is the longest topic, in base64url characters.
+	topicLenMax = 32
+	// detailLenMax is the most of a response body kept in Result.Detail.
+	detailLenMax = 512
+)
+
+// Urgency is the urgency of a push message, see RFC 8030, section 5.3.
+type Urgency string
+
+const (
+	UrgencyVeryLow Urgency = "very-low"
+	UrgencyLow     Urgency = "low"
+	UrgencyNormal  Urgency = "normal"
+	UrgencyHigh    Urgency = "high"
+)
+
+// Message is a push message.
+type Message struct {
+	// Payload is encrypted for the subscription; messages without payload have no body.
+	Payload []byte
+	// TTL is how long the push service keeps an undelivered message, in whole seconds.
+	// Zero asks for immediate delivery or none.
+	TTL time.Duration
+	// Urgency is left to the push service if empty.
+	Urgency Urgency
+	// Topic replaces the undelivered message of the same topic; up to 32 base64url characters.
+	Topic string
+}
+
+// Status classifies the response of a push service.
+type Status int
+
+const (
+	// StatusCreated is a message accepted by the push service, 201 or another 2xx.
+	StatusCreated Status = iota
+	// StatusGone is a subscription that expired or was unsubscribed, 404 or 410. It should be deleted.
+	StatusGone
+	// StatusTooLarge is a payload the push service does not accept, 413.
+	StatusTooLarge
+	// StatusTooManyRequests is a sender that has to slow down, 429. See Result.RetryAfter.
+	StatusTooManyRequests
+	// StatusServerError is a failure of the push service, 5xx. The message may be sent again later.
+	StatusServerError
+	// StatusRejected is any other response, e.g. 400 for a malformed request or 401 and 403 for authorization.
+	StatusRejected
+)
+
+func (s Status) String() string {
+	switch s {
+	case StatusCreated:
+		return "created"
+	case StatusGone:
+		return "gone"
+	case StatusTooLarge:
+		return "too large"
+	case StatusTooManyRequests:
+		return "too many requests"
+	case StatusServerError:
+		return "server error"
+	case StatusRejected:
+		return "rejected"
+	default:
+		return "Status(" + strconv.Itoa(int(s)) + ")"
+	}
+}
+
+// Result is the outcome of sending a push message.
+type Result struct {
+	Status     Status
+	StatusCode int
+	// Location is the push message resource of a created message.
+	Location string
+	// RetryAfter is the delay requested by Retry-After, zero if absent.
+	RetryAfter time.Duration
+	// Detail is the start of the response body of an unsuccessful request, for diagnostics.
+	Detail string
+}
+
+// Sender sends push messages to subscriptions, see RFC 8030.
+type Sender struct {
+	// Client sends the requests. http.DefaultClient is used if nil.
+	Client *http.Client
+	// VAPID authorizes the requests; requests are not authorized if nil.
+	VAPID *VAPID
+	// Options are added to the encryption of payloads, see EncryptFor.
+	Options []httpece.Option
+
+	now func() time.Time
+}
+
+// Send sends the message to the subscription, and classifies the response.
+// It only fails for messages that cannot be sent; responses of the push service are reported by the result.
+func (s *Sender) Send(ctx context.Context, sub *Subscription, msg *Message) (*Result, error) {
+	req, err := s.newRequest(ctx, sub, msg)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := s.client().Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	result := &Result{StatusCode: res.StatusCode}
+	switch code := res.StatusCode; {
+	case code >= 200 && code <= 299:
+		result.Status = StatusCreated
+		result.Location = res.Header.Get(headerLocation)
+		// Reading the body lets the connection be reused.
+		_, _ = io.Copy(io.Discard, io.LimitReader(res.Body, detailLenMax))
+		return result, nil
+	case code == http.StatusNotFound || code == http.StatusGone:
+		result.Status = StatusGone
+	case code == http.StatusRequestEntityTooLarge:
+		result.Status = StatusTooLarge
+	case code == http.StatusTooManyRequests:
+		result.Status = StatusTooManyRequests
+	case code >= 500:
+		result.Status = StatusServerError
+	default:
+		result.Status = StatusRejected
+	}
+	result.RetryAfter = s.retryAfter(res.Header.Get(headerRetryAfter))
+	// The detail is best effort.
+	detail, _ := io.ReadAll(io.LimitReader(res.Body, detailLenMax))
+	result.Detail = strings.TrimSpace(string(detail))
+	return result, nil
+}
+
+// newRequest returns the request that sends the message.
+func (s *Sender) newRequest(ctx context.Context, sub *Subscription, msg *Message) (*http.Request, error) {
+	if err := sub.Validate(); err != nil {
+		return nil, err
+	}
+	if msg.TTL < 0 {
+		return nil, fmt.Errorf("invalid TTL %v", msg.TTL)
+	}
+	switch msg.Urgency {
+	case "", UrgencyVeryLow, UrgencyLow, UrgencyNormal, UrgencyHigh:
+	default:
+		return nil, fmt.Errorf("invalid urgency %q", msg.Urgency)
+	}
+	if !validTopic(msg.Topic) {
+		return nil, fmt.Errorf("invalid topic %q: must be up to %d base64url characters", msg.Topic, topicLenMax)
+	}
+
+	var body []byte
+	if len(msg.Payload) > 0 {
+		var err error
+		if body, err = EncryptFor(sub, msg.Payload, s.Options...); err != nil {
+			return nil, err
+		}
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, sub.Endpoint, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set(headerTTL, strconv.FormatInt(int64(msg.TTL/time.Second), 10))
+	if msg.Urgency != "" {
+		req.Header.Set(headerUrgency, string(msg.Urgency))
+	}
+	if msg.Topic != "" {
+		req.Header.Set(headerTopic, msg.Topic)
+	}
+	if body != nil {
+		req.Header.Set(headerContentEncoding, string(httpece.AES128GCM))
+		req.Header.Set(headerContentType, "application/octet-stream")
+	}
+	if s.VAPID != nil {
+		if err := s.VAPID.Authorize(req); err != nil {
+			return nil, err
+		}
+	}
+	return req, nil
+}
+
+// retryAfter parses a Retry-After value, delay seconds or an HTTP date.
+func (s *Sender) retryAfter(value string) time.Duration {
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return max(time.Duration(seconds)*time.Second, 0)
+	}
+	date, err := http.ParseTime(value)
+	if err != nil {
+		return 0
+	}
+	now := time.Now()
+	if s.now != nil {
+		now = s.now()
+	}
+	return max(date.Sub(now), 0)
+}
+
+func (s *Sender) client() *http.Client {
+	if s.Client != nil {
+		return s.Client
+	}
+	return http.DefaultClient
+}
+
+// validTopic reports whether the topic is empty or up to 32 base64url characters.
+func validTopic(topic string) bool {
+	if len(topic) > topicLenMax {
+		return false
+	}
+	for _, c := range topic {
+		if !('A' <= c && c <= 'Z' || 'a' <= c && c <= 'z' || '0' <= c && c <= '9' || c == '-' || c == '_') {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,184 @@
+/*
+ * Copyright (c) 2019 Zenichi Amano
+ *
+ * This file is part of http-ece, which is MIT licensed.
+ * See http://opensource.org/licenses/MIT
+ */
+
+package webpush
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	httpece "github.com/crow-misia/http-ece"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSender_Send(t *testing.T) {
+	key, err := GenerateVAPIDKey()
+	assert.Nil(t, err)
+
+	var got *http.Request
+	var body []byte
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r
+		body, _ = io.ReadAll(r.Body)
+		w.Header().Set("Location", "https://push.example.net/message/qDIYHNcfAIPP_5ITvURr-d6BGtYnTRnk")
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer server.Close()
+
+	sub := subscription(t)
+	sub.Endpoint = server.URL + "/push/abc"
+	sender := &Sender{Client: server.Client(), VAPID: &VAPID{Key: key, Subject: "mailto:push@example.com"}}
+	result, err := sender.Send(context.Background(), sub, &Message{
+		Payload: []byte("hello"),
+		TTL:     90 * time.Second,
+		Urgency: UrgencyHigh,
+		Topic:   "upd_ate-1",
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, &Result{
+		Status:     StatusCreated,
+		StatusCode: http.StatusCreated,
+		Location:   "https://push.example.net/message/qDIYHNcfAIPP_5ITvURr-d6BGtYnTRnk",
+	}, result)
+
+	assert.Equal(t, http.MethodPost, got.Method)
+	assert.Equal(t, "/push/abc", got.URL.Path)
+	assert.Equal(t, "90", got.Header.Get("TTL"))
+	assert.Equal(t, "high", got.Header.Get("Urgency"))
+	assert.Equal(t, "upd_ate-1", got.Header.Get("Topic"))
+	assert.Equal(t, "aes128gcm", got.Header.Get("Content-Encoding"))
+	assert.True(t, strings.HasPrefix(got.Header.Get("Authorization"), "vapid t="))
+
+	plaintext, err := httpece.Decrypt(body,
+		httpece.WithPrivate(d(t, "q1dXpw3UpT5VOmu_cf_v6ih07Aems3njxI-JWgLcM94")),
+		httpece.WithAuthSecret(d(t, uaAuth)))
+	assert.Nil(t, err)
+	assert.Equal(t, "hello", string(plaintext))
+
+	// Without payload and authorization.
+	result, err = (&Sender{Client: server.Client()}).Send(context.Background(), sub, &Message{})
+	assert.Nil(t, err)
+	assert.Equal(t, StatusCreated, result.Status)
+	assert.Empty(t, body)
+	assert.Equal(t, "0", got.Header.Get("TTL"))
+	assert.Equal(t, "", got.Header.Get("Content-Encoding"))
+	assert.Equal(t, "", got.Header.Get("Authorization"))
+	assert.Equal(t, "", got.Header.Get("Urgency"))
+}
+
+func TestSender_Status(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		code       int
+		retryAfter string
+		status     Status
+		delay      time.Duration
+	}{
+		{http.StatusOK, "", StatusCreated, 0},
+		{http.StatusNotFound, "", StatusGone, 0},
+		{http.StatusGone, "", StatusGone, 0},
+		{http.StatusRequestEntityTooLarge, "", StatusTooLarge, 0},
+		{http.StatusTooManyRequests, "120", StatusTooManyRequests, 2 * time.Minute},
+		{http.StatusTooManyRequests, "Mon, 01 Jan 2024 00:00:30 GMT", StatusTooManyRequests, 30 * time.Second},
+		{http.StatusTooManyRequests, "soon", StatusTooManyRequests, 0},
+		{http.StatusServiceUnavailable, "5", StatusServerError, 5 * time.Second},
+		{http.StatusInternalServerError, "", StatusServerError, 0},
+		{http.StatusBadRequest, "", StatusRejected, 0},
+		{http.StatusForbidden, "", StatusRejected, 0},
+	}
+	for _, tt := range tests {
+		t.Run(http.StatusText(tt.code), func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if tt.retryAfter != "" {
+					w.Header().Set("Retry-After", tt.retryAfter)
+				}
+				w.WriteHeader(tt.code)
+				_, _ = io.WriteString(w, " detail\n")
+			}))
+			defer server.Close()
+
+			sub := subscription(t)
+			sub.Endpoint = server.URL
+			sender := &Sender{Client: server.Client(), now: func() time.Time { return now }}
+			result, err := sender.Send(context.Background(), sub, &Message{Payload: []byte("hello")})
+			assert.Nil(t, err)
+			assert.Equal(t, tt.code, result.StatusCode)
+			assert.Equal(t, tt.status, result.Status, result.Status.String())
+			assert.Equal(t, tt.delay, result.RetryAfter)
+			if tt.status != StatusCreated {
+				assert.Equal(t, "detail", result.Detail)
+			}
+		})
+	}
+}
+
+// roundTripFunc is an http.RoundTripper that calls itself.
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+// eofBody records whether it has been read to the end.
+type eofBody struct {
+	io.Reader
+	eof bool
+}
+
+func (b *eofBody) Read(p []byte) (int, error) {
+	n, err := b.Reader.Read(p)
+	if err == io.EOF {
+		b.eof = true
+	}
+	return n, err
+}
+
+func (b *eofBody) Close() error {
+	return nil
+}
+
+func TestSender_DrainBody(t *testing.T) {
+	body := &eofBody{Reader: strings.NewReader("created\n")}
+	client := &http.Client{Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		return &http.Response{StatusCode: http.StatusCreated, Header: http.Header{}, Body: body, Request: req}, nil
+	})}
+
+	result, err := (&Sender{Client: client}).Send(context.Background(), subscription(t), &Message{})
+	assert.Nil(t, err)
+	assert.Equal(t, StatusCreated, result.Status)
+	// The body is read, so that the connection can be reused.
+	assert.True(t, body.eof)
+}
+
+func TestSender_InvalidMessage(t *testing.T) {
+	// Nothing is sent.
+	sender := &Sender{}
+	tests := map[string]*Message{
+		"ttl":     {TTL: -time.Second},
+		"urgency": {Urgency: "urgent"},
+		"topic":   {Topic: "a/b"},
+		"long":    {Topic: strings.Repeat("a", 33)},
+		"payload": {Payload: make([]byte, MaxPayloadSize+1)},
+	}
+	for name, msg := range tests {
+		_, err := sender.Send(context.Background(), subscription(t), msg)
+		assert.NotNil(t, err, name)
+	}
+
+	_, err := sender.Send(context.Background(), &Subscription{}, &Message{})
+	assert.NotNil(t, err)
+}
+
+func TestStatus_String(t *testing.T) {
+	assert.Equal(t, "gone", StatusGone.String())
+	assert.Equal(t, "Status(42)", Status(42).String())
+}
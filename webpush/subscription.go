@@ -0,0 +1,78 @@
+/*
+ * Copyright (c) 2019 Zenichi Amano
+ *
+ * This file is part of http-ece, which is MIT licensed.
+ * See http://opensource.org/licenses/MIT
+ */
+
+// Package webpush sends messages to the push subscriptions of browsers:
+// payloads are encrypted as specified by RFC 8291, and the application server is identified by VAPID, RFC 8292.
+package webpush
+
+import (
+	"crypto/ecdh"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// authSecretLen is the length of the authentication secret of a subscription.
+const authSecretLen = 16
+
+// Subscription is the PushSubscription of a browser, as serialized by its toJSON method.
+type Subscription struct {
+	Endpoint       string `json:"endpoint"`
+	ExpirationTime *int64 `json:"expirationTime,omitempty"` // milliseconds since the epoch, nil if none
+	Keys           Keys   `json:"keys"`
+}
+
+// Keys are the keys of a subscription.
+type Keys struct {
+	P256dh Key `json:"p256dh"` // public key of the user agent
+	Auth   Key `json:"auth"`   // authentication secret
+}
+
+// Key is binary key material encoded as base64url in JSON.
+// Padding is optional when decoding, and omitted when encoding.
+type Key []byte
+
+func (k Key) MarshalText() ([]byte, error) {
+	return base64.RawURLEncoding.AppendEncode(nil, k), nil
+}
+
+func (k *Key) UnmarshalText(b []byte) error {
+	v, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(string(b), "="))
+	if err != nil {
+		return fmt.Errorf("invalid key: %w", err)
+	}
+	*k = v
+	return nil
+}
+
+// ParseSubscription parses and validates the JSON of a subscription.
+func ParseSubscription(data []byte) (*Subscription, error) {
+	var sub Subscription
+	if err := json.Unmarshal(data, &sub); err != nil {
+		return nil, err
+	}
+	if err := sub.Validate(); err != nil {
+		return nil, err
+	}
+	return &sub, nil
+}
+
+// Validate checks that the subscription has an endpoint, a P-256 public key and a 16-byte authentication secret.
+func (s *Subscription) Validate() error {
+	if s.Endpoint == "" {
+		return errors.New("missing endpoint")
+	}
+	if _, err := ecdh.P256().NewPublicKey(s.Keys.P256dh); err != nil {
+		return fmt.Errorf("invalid p256dh key: %w", err)
+	}
+	if len(s.Keys.Auth) != authSecretLen {
+		return fmt.Errorf("invalid auth secret length %d: must be %d bytes", len(s.Keys.Auth), authSecretLen)
+	}
+	return nil
+}
@@ -0,0 +1,68 @@
+/*
+ * Copyright (c) 2019 Zenichi Amano
+ *
+ * This file is part of http-ece, which is MIT licensed.
+ * See http://opensource.org/licenses/MIT
+ */
+
+package webpush
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Keys of the user agent in RFC 8291, appendix A.
+const (
+	uaPublic = "BCVxsr7N_eNgVRqvHtD0zTZsEc6-VV-JvLexhqUzORcxaOzi6-AYWXvTBHm4bjyPjs7Vd8pZGH6SRpkNtoIAiw4"
+	uaAuth   = "BTBZMqHH6r4Tts7J_aSIgg"
+)
+
+func d(t *testing.T, s string) []byte {
+	t.Helper()
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	assert.Nil(t, err)
+	return b
+}
+
+func TestParseSubscription(t *testing.T) {
+	sub, err := ParseSubscription([]byte(`{
+		"endpoint": "https://push.example.net/push/JzLQ3raZJfFBR0aqvOMsLrt54w4rJUsV",
+		"expirationTime": null,
+		"keys": {"p256dh": "` + uaPublic + `", "auth": "` + uaAuth + `=="}
+	}`))
+	assert.Nil(t, err)
+	assert.Equal(t, "https://push.example.net/push/JzLQ3raZJfFBR0aqvOMsLrt54w4rJUsV", sub.Endpoint)
+	assert.Nil(t, sub.ExpirationTime)
+	assert.Equal(t, Key(d(t, uaPublic)), sub.Keys.P256dh)
+	assert.Equal(t, Key(d(t, uaAuth)), sub.Keys.Auth)
+
+	b, err := json.Marshal(sub)
+	assert.Nil(t, err)
+	assert.JSONEq(t, `{
+		"endpoint": "https://push.example.net/push/JzLQ3raZJfFBR0aqvOMsLrt54w4rJUsV",
+		"keys": {"p256dh": "`+uaPublic+`", "auth": "`+uaAuth+`"}
+	}`, string(b))
+
+	sub, err = ParseSubscription([]byte(`{"endpoint": "https://push.example.net/", "expirationTime": 1700000000000,
+		"keys": {"p256dh": "` + uaPublic + `", "auth": "` + uaAuth + `"}}`))
+	assert.Nil(t, err)
+	assert.Equal(t, int64(1700000000000), *sub.ExpirationTime)
+}
+
+func TestParseSubscription_Invalid(t *testing.T) {
+	tests := map[string]string{
+		"json":     `{`,
+		"endpoint": `{"keys": {"p256dh": "` + uaPublic + `", "auth": "` + uaAuth + `"}}`,
+		"encoding": `{"endpoint": "https://push.example.net/", "keys": {"p256dh": "` + uaPublic + `", "auth": "BTBZMqHH6r4Tts7J/aSIgg"}}`,
+		"p256dh":   `{"endpoint": "https://push.example.net/", "keys": {"p256dh": "BCVxsr7N", "auth": "` + uaAuth + `"}}`,
+		"auth":     `{"endpoint": "https://push.example.net/", "keys": {"p256dh": "` + uaPublic + `", "auth": "BTBZ"}}`,
+	}
+	for name, data := range tests {
+		_, err := ParseSubscription([]byte(data))
+		assert.NotNil(t, err, name)
+	}
+}
@@ -0,0 +1,215 @@
+/*
+ * Copyright (c) 2019 Zenichi Amano
+ *
+ * This file is part of http-ece, which is MIT licensed.
+ * See http://opensource.org/licenses/MIT
+ */
+
+package webpush
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	httpece "github.com/crow-misia/http-ece"
+)
+
+const (
+	headerAuthorization = "Authorization"
+	headerCryptoKey     = "Crypto-Key"
+
+	// tokenExpirationDefault is the lifetime of tokens without VAPID.Expiration.
+	tokenExpirationDefault = 12 * time.Hour
+	// tokenExpirationMax is the longest lifetime push services accept.
+	tokenExpirationMax = 24 * time.Hour
+	// tokenRefreshMargin is how long before their expiration cached tokens are replaced.
+	tokenRefreshMargin = 5 * time.Minute
+)
+
+// VAPIDKey is the key pair that identifies an application server to push services, see RFC 8292.
+type VAPIDKey struct {
+	private *ecdsa.PrivateKey
+	public  []byte // uncompressed point
+}
+
+// GenerateVAPIDKey generates a new key pair.
+func GenerateVAPIDKey() (*VAPIDKey, error) {
+	private, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	return newVAPIDKey(private)
+}
+
+// ParseVAPIDKey parses a private key in the form of PrivateKey.
+func ParseVAPIDKey(private string) (*VAPIDKey, error) {
+	b, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(private, "="))
+	if err != nil {
+		return nil, fmt.Errorf("invalid VAPID key: %w", err)
+	}
+	key, err := ecdsa.ParseRawPrivateKey(elliptic.P256(), b)
+	if err != nil {
+		return nil, fmt.Errorf("invalid VAPID key: %w", err)
+	}
+	return newVAPIDKey(key)
+}
+
+func newVAPIDKey(private *ecdsa.PrivateKey) (*VAPIDKey, error) {
+	public, err := private.PublicKey.Bytes()
+	if err != nil {
+		return nil, err
+	}
+	return &VAPIDKey{private: private, public: public}, nil
+}
+
+// PrivateKey returns the private key as base64url, to be kept secret.
+func (k *VAPIDKey) PrivateKey() string {
+	// Bytes only fails for keys that ParseRawPrivateKey and GenerateKey do not return.
+	b, _ := k.private.Bytes()
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// PublicKey returns the public key as base64url, the applicationServerKey of PushManager.subscribe.
+func (k *VAPIDKey) PublicKey() string {
+	return base64.RawURLEncoding.EncodeToString(k.public)
+}
+
+// sign returns an ES256 JSON Web Token with the claims.
+func (k *VAPIDKey) sign(claims any) (string, error) {
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+
+	enc := base64.RawURLEncoding
+	token := enc.EncodeToString([]byte(`{"typ":"JWT","alg":"ES256"}`)) + "." + enc.EncodeToString(payload)
+	digest := sha256.Sum256([]byte(token))
+	r, s, err := ecdsa.Sign(rand.Reader, k.private, digest[:])
+	if err != nil {
+		return "", err
+	}
+	// JWS signatures are the fixed-size concatenation of r and s.
+	sig := make([]byte, 64)
+	r.FillBytes(sig[:32])
+	s.FillBytes(sig[32:])
+	return token + "." + enc.EncodeToString(sig), nil
+}
+
+// VAPID authorizes requests to push services with tokens of its key.
+// Tokens are cached per push service origin, and replaced shortly before they expire.
+// A VAPID must not be copied after first use.
+type VAPID struct {
+	Key *VAPIDKey
+	// Subject is the contact of the application server, a "mailto:" or "https:" URI.
+	Subject string
+	// Expiration is the lifetime of the tokens, 12 hours if zero. Push services reject more than 24 hours.
+	Expiration time.Duration
+	// Legacy selects the WebPush scheme of the drafts, which carries the public key in Crypto-Key,
+	// for push services that predate RFC 8292.
+	Legacy bool
+
+	mu     sync.Mutex
+	tokens map[string]cachedToken
+	now    func() time.Time
+}
+
+type cachedToken struct {
+	token   string
+	expires time.Time
+}
+
+type claims struct {
+	Aud string `json:"aud"`
+	Exp int64  `json:"exp"`
+	Sub string `json:"sub,omitempty"`
+}
+
+// Token returns a token for the push service of the endpoint.
+func (v *VAPID) Token(endpoint string) (string, error) {
+	if v.Key == nil {
+		return "", errors.New("missing VAPID key")
+	}
+	aud, err := origin(endpoint)
+	if err != nil {
+		return "", err
+	}
+	expiration := v.Expiration
+	if expiration == 0 {
+		expiration = tokenExpirationDefault
+	}
+	if expiration < 0 || expiration > tokenExpirationMax {
+		return "", fmt.Errorf("invalid VAPID expiration %v: must be at most %v", expiration, tokenExpirationMax)
+	}
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	now := time.Now()
+	if v.now != nil {
+		now = v.now()
+	}
+	if c, ok := v.tokens[aud]; ok && now.Before(c.expires.Add(-tokenRefreshMargin)) {
+		return c.token, nil
+	}
+
+	expires := now.Add(expiration)
+	token, err := v.Key.sign(claims{Aud: aud, Exp: expires.Unix(), Sub: v.Subject})
+	if err != nil {
+		return "", err
+	}
+	if v.tokens == nil {
+		v.tokens = make(map[string]cachedToken)
+	}
+	v.tokens[aud] = cachedToken{token: token, expires: expires}
+	return token, nil
+}
+
+// Authorize sets the Authorization header of a request to the push service of the endpoint of req.
+// With Legacy, the public key is added to the Crypto-Key header instead of Authorization.
+func (v *VAPID) Authorize(req *http.Request) error {
+	token, err := v.Token(req.URL.String())
+	if err != nil {
+		return err
+	}
+
+	if !v.Legacy {
+		req.Header.Set(headerAuthorization, "vapid t="+token+", k="+v.Key.PublicKey())
+		return nil
+	}
+
+	req.Header.Set(headerAuthorization, "WebPush "+token)
+	crypto, err := httpece.ParseCryptoHeaders(req.Header)
+	if err != nil {
+		return err
+	}
+	// The key joins the entry of dh, if any.
+	if len(crypto.CryptoKey) == 0 {
+		crypto.CryptoKey = append(crypto.CryptoKey, httpece.CryptoKeyParams{})
+	}
+	crypto.CryptoKey[0].P256ECDSA = v.Key.public
+	req.Header.Set(headerCryptoKey, httpece.FormatCryptoKey(crypto.CryptoKey...))
+	return nil
+}
+
+// origin returns the origin of the URL, the audience of tokens.
+func origin(endpoint string) (string, error) {
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return "", err
+	}
+	if u.Scheme == "" || u.Host == "" {
+		return "", fmt.Errorf("invalid endpoint %q", endpoint)
+	}
+	return strings.ToLower(u.Scheme) + "://" + strings.ToLower(u.Host), nil
+}
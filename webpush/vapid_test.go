@@ -0,0 +1,148 @@
+/*
+ * Copyright (c) 2019 Zenichi Amano
+ *
+ * This file is part of http-ece, which is MIT licensed.
+ * See http://opensource.org/licenses/MIT
+ */
+
+package webpush
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	httpece "github.com/crow-misia/http-ece"
+	"github.com/stretchr/testify/assert"
+)
+
+// verifyToken checks the signature of a token with the public key, and returns its claims.
+func verifyToken(t *testing.T, token string, public string) claims {
+	t.Helper()
+	parts := strings.Split(token, ".")
+	assert.Len(t, parts, 3)
+
+	assert.Equal(t, `{"typ":"JWT","alg":"ES256"}`, string(d(t, parts[0])))
+	var c claims
+	assert.Nil(t, json.Unmarshal(d(t, parts[1]), &c))
+
+	key, err := ecdsa.ParseUncompressedPublicKey(elliptic.P256(), d(t, public))
+	assert.Nil(t, err)
+	sig := d(t, parts[2])
+	assert.Len(t, sig, 64)
+	digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	r, s := new(big.Int).SetBytes(sig[:32]), new(big.Int).SetBytes(sig[32:])
+	assert.True(t, ecdsa.Verify(key, digest[:], r, s))
+	return c
+}
+
+func TestVAPIDKey(t *testing.T) {
+	key, err := GenerateVAPIDKey()
+	assert.Nil(t, err)
+	assert.Len(t, d(t, key.PublicKey()), 65)
+	assert.Len(t, d(t, key.PrivateKey()), 32)
+
+	parsed, err := ParseVAPIDKey(key.PrivateKey() + "=")
+	assert.Nil(t, err)
+	assert.Equal(t, key.PublicKey(), parsed.PublicKey())
+
+	for _, s := range []string{"", "!!!", base64.RawURLEncoding.EncodeToString(make([]byte, 32))} {
+		_, err := ParseVAPIDKey(s)
+		assert.NotNil(t, err, s)
+	}
+}
+
+func TestVAPID_Token(t *testing.T) {
+	key, err := GenerateVAPIDKey()
+	assert.Nil(t, err)
+	now := time.Unix(1700000000, 0)
+	v := &VAPID{Key: key, Subject: "mailto:push@example.com", now: func() time.Time { return now }}
+
+	token, err := v.Token("https://Push.Example.net:8443/push/abc")
+	assert.Nil(t, err)
+	assert.Equal(t, claims{
+		Aud: "https://push.example.net:8443",
+		Exp: now.Add(12 * time.Hour).Unix(),
+		Sub: "mailto:push@example.com",
+	}, verifyToken(t, token, key.PublicKey()))
+
+	// Cached per origin.
+	cached, err := v.Token("https://push.example.net:8443/push/def")
+	assert.Nil(t, err)
+	assert.Equal(t, token, cached)
+	other, err := v.Token("https://updates.push.example.com/push/abc")
+	assert.Nil(t, err)
+	assert.NotEqual(t, token, other)
+
+	// Replaced shortly before the expiration.
+	now = now.Add(12*time.Hour - 10*time.Minute)
+	cached, err = v.Token("https://push.example.net:8443/push/abc")
+	assert.Nil(t, err)
+	assert.Equal(t, token, cached)
+	now = now.Add(6 * time.Minute)
+	renewed, err := v.Token("https://push.example.net:8443/push/abc")
+	assert.Nil(t, err)
+	assert.NotEqual(t, token, renewed)
+	assert.Equal(t, now.Add(12*time.Hour).Unix(), verifyToken(t, renewed, key.PublicKey()).Exp)
+}
+
+func TestVAPID_Errors(t *testing.T) {
+	key, err := GenerateVAPIDKey()
+	assert.Nil(t, err)
+
+	_, err = (&VAPID{}).Token("https://push.example.net/")
+	assert.NotNil(t, err)
+	_, err = (&VAPID{Key: key}).Token("/push/abc")
+	assert.NotNil(t, err)
+	_, err = (&VAPID{Key: key, Expiration: 25 * time.Hour}).Token("https://push.example.net/")
+	assert.NotNil(t, err)
+}
+
+func TestVAPID_Authorize(t *testing.T) {
+	key, err := GenerateVAPIDKey()
+	assert.Nil(t, err)
+
+	req, err := http.NewRequest(http.MethodPost, "https://push.example.net/push/abc", nil)
+	assert.Nil(t, err)
+	v := &VAPID{Key: key}
+	assert.Nil(t, v.Authorize(req))
+
+	scheme, params, _ := strings.Cut(req.Header.Get("Authorization"), " ")
+	assert.Equal(t, "vapid", scheme)
+	token, k, ok := strings.Cut(params, ", k=")
+	assert.True(t, ok)
+	assert.Equal(t, key.PublicKey(), k)
+	c := verifyToken(t, strings.TrimPrefix(token, "t="), key.PublicKey())
+	assert.Equal(t, "https://push.example.net", c.Aud)
+	assert.Equal(t, "", c.Sub)
+}
+
+func TestVAPID_AuthorizeLegacy(t *testing.T) {
+	key, err := GenerateVAPIDKey()
+	assert.Nil(t, err)
+	dh := d(t, uaPublic)
+
+	req, err := http.NewRequest(http.MethodPost, "https://push.example.net/push/abc", nil)
+	assert.Nil(t, err)
+	req.Header.Set("Crypto-Key", httpece.FormatCryptoKey(httpece.CryptoKeyParams{DH: dh}))
+	v := &VAPID{Key: key, Legacy: true}
+	assert.Nil(t, v.Authorize(req))
+
+	token, ok := strings.CutPrefix(req.Header.Get("Authorization"), "WebPush ")
+	assert.True(t, ok)
+	verifyToken(t, token, key.PublicKey())
+	crypto, err := httpece.ParseCryptoHeaders(req.Header)
+	assert.Nil(t, err)
+	assert.Equal(t, []httpece.CryptoKeyParams{{DH: dh, P256ECDSA: d(t, key.PublicKey())}}, crypto.CryptoKey)
+
+	req.Header.Del("Crypto-Key")
+	assert.Nil(t, v.Authorize(req))
+	assert.Equal(t, "p256ecdsa="+key.PublicKey(), req.Header.Get("Crypto-Key"))
+}